@@ -1,10 +1,14 @@
 package config
 
 import (
-	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode/utf8"
@@ -32,14 +36,143 @@ func (k KeySpec) ToXGB() string {
 			out = append(out, "Mod1")
 		case 'S':
 			out = append(out, "Shift")
+		case '2':
+			out = append(out, "Mod2")
+		case '3':
+			out = append(out, "Mod3")
 		case '4':
 			out = append(out, "Mod4")
+		case '5':
+			out = append(out, "Mod5")
 		}
 	}
 	out = append(out, k.Key)
 	return strings.Join(out, "-")
 }
 
+// validMods are the modifiers that key and mouse specs may use:
+// Control, Meta (Mod1), Shift and Mod2 to Mod5.
+const validMods = "CMS2345"
+
+// keyAliases maps common names of keys to their keysym names.
+var keyAliases = map[string]string{
+	"Backspace": "BackSpace",
+	"Del":       "Delete",
+	"Enter":     "Return",
+	"Esc":       "Escape",
+	"Ins":       "Insert",
+	"PageDown":  "Next",
+	"PageUp":    "Prior",
+	"PgDn":      "Next",
+	"PgUp":      "Prior",
+	"Space":     "space",
+}
+
+// mouseFunctions are the functions that mouse bindings may invoke.
+var mouseFunctions = map[string]bool{
+	"window_lower":  true,
+	"window_move":   true,
+	"window_resize": true,
+}
+
+// parseKeySpec parses a key spec of the form [mods-]key.
+func parseKeySpec(s string) (KeySpec, error) {
+	var key KeySpec
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) == 2 {
+		key = KeySpec{Mods: parts[0], Key: parts[1]}
+	} else {
+		key = KeySpec{Key: parts[0]}
+	}
+	for _, c := range key.Mods {
+		if !strings.ContainsRune(validMods, c) {
+			return key, fmt.Errorf("invalid modifier %q in %q", c, s)
+		}
+	}
+	if key.Key == "" {
+		return key, fmt.Errorf("missing key in %q", s)
+	}
+	if alias, ok := keyAliases[key.Key]; ok {
+		key.Key = alias
+	}
+	return key, nil
+}
+
+// parseMouseSpec parses a mouse spec of the form [mods-]button.
+func parseMouseSpec(s string) (KeySpec, error) {
+	parts := strings.SplitN(s, "-", 2)
+	key := KeySpec{Key: parts[len(parts)-1]}
+	if len(parts) == 2 {
+		key.Mods = parts[0]
+	}
+	for _, c := range key.Mods {
+		if !strings.ContainsRune(validMods, c) {
+			return key, fmt.Errorf("invalid modifier %q in %q", c, s)
+		}
+	}
+	if n, err := strconv.Atoi(key.Key); err != nil || n < 1 {
+		return key, fmt.Errorf("invalid mouse button %q", key.Key)
+	}
+	return key, nil
+}
+
+// checkFunction returns an error if name is neither a function nor a
+// command defined so far.
+func (cfg *Config) checkFunction(name string) error {
+	if cfg.opts.Function != nil && cfg.opts.Function(name) {
+		return nil
+	}
+	if _, ok := cfg.Commands[name]; ok {
+		return nil
+	}
+	return fmt.Errorf("unknown function %q", name)
+}
+
+// FocusPolicy determines how windows get the input focus.
+type FocusPolicy int
+
+const (
+	// FocusSloppy focuses windows when the pointer enters them and
+	// keeps the focus when the pointer moves to the root window.
+	FocusSloppy FocusPolicy = iota
+	// FocusStrict is like FocusSloppy, but removes the focus when the
+	// pointer moves to the root window.
+	FocusStrict
+	// FocusClick focuses windows when they are clicked.
+	FocusClick
+)
+
+// Placement determines where newly mapped windows are placed.
+type Placement int
+
+const (
+	// PlacePointer centers windows on the pointer.
+	PlacePointer Placement = iota
+	// PlaceCenter centers windows on the screen.
+	PlaceCenter
+	// PlaceCascade places windows diagonally below the previously
+	// placed one.
+	PlaceCascade
+	// PlaceSmart places windows where they overlap the least with
+	// other windows.
+	PlaceSmart
+)
+
+// TileLayout is a layout that windows are automatically arranged in.
+type TileLayout int
+
+const (
+	// TileNone doesn't arrange windows.
+	TileNone TileLayout = iota
+	// TileMasterStack places the master windows on the left and
+	// stacks the remaining windows on the right.
+	TileMasterStack
+	// TileMonocle makes every window fill the screen.
+	TileMonocle
+	// TileGrid arranges windows in a grid.
+	TileGrid
+)
+
 type Config struct {
 	BorderWidth int
 	Snapdist    int
@@ -53,8 +186,40 @@ type Config struct {
 	MouseBinds  map[string]KeySpec
 	MoveAmount  int // default: 1
 	Sticky      bool
+	Swallow     []string
+	Focus       FocusPolicy
+	ClickRaise  bool // default: true
+	AutoRaise   int  // in milliseconds, 0 disables it
+	Placement   Placement
+	ManualPlace []string // window classes, or "all"
+	Tiling      TileLayout
+	MasterRatio int // in percent, default: 50
+	MasterCount int // default: 1
+	Rules       []Rule
+
+	// pos is the position of the directive being parsed, and keyPos
+	// the positions of the key bindings.
+	pos    Pos
+	keyPos map[KeySpec]Pos
+
+	// monitors is set if a section depends on the number of
+	// monitors.
+	monitors bool
+	// opts are the options the configuration is parsed with.
+	opts Options
 }
 
+// DependsOnMonitors reports whether the configuration has sections
+// that depend on the number of monitors, and has to be parsed again
+// when it changes.
+func (cfg *Config) DependsOnMonitors() bool {
+	return cfg.monitors
+}
+
+// variadic is the number of arguments of directives that take any
+// positive number of them.
+const variadic = -1
+
 type parseDecl struct {
 	num int
 	fn  func(cfg *Config, in []string) error
@@ -80,23 +245,55 @@ var parseMap = map[string]parseDecl{
 		return nil
 	}},
 
+	"autoraise": {1, func(cfg *Config, in []string) error {
+		i, err := strconv.Atoi(in[0])
+		if err != nil {
+			return err
+		}
+		cfg.AutoRaise = i
+		return nil
+	}},
+
 	"bind": {2, func(cfg *Config, in []string) error {
-		parts := strings.SplitN(in[0], "-", 2)
-		var key KeySpec
-		switch len(parts) {
-		case 1:
-			key = KeySpec{Key: parts[0]}
-		case 2:
-			// TODO make sure the mod is valid
-			key = KeySpec{Mods: parts[0], Key: parts[1]}
-		default:
-			return fmt.Errorf("invalid keyspec %q", in[0])
+		key, err := parseKeySpec(in[0])
+		if err != nil {
+			return err
 		}
 		if in[1] == "unmap" {
 			delete(cfg.Binds, key)
-		} else {
-			cfg.Binds[key] = in[1]
+			return nil
+		}
+		if err := cfg.checkFunction(in[1]); err != nil {
+			return err
+		}
+		cfg.Binds[key] = in[1]
+		cfg.keyPos[key] = cfg.pos
+		return nil
+	}},
+
+	"bind-key": {2, func(cfg *Config, in []string) error {
+		key, err := parseKeySpec(in[0])
+		if err != nil {
+			return err
+		}
+		if err := cfg.checkFunction(in[1]); err != nil {
+			return err
+		}
+		cfg.Binds[key] = in[1]
+		cfg.keyPos[key] = cfg.pos
+		return nil
+	}},
+
+	"bind-mouse": {2, func(cfg *Config, in []string) error {
+		key, err := parseMouseSpec(in[0])
+		if err != nil {
+			return err
 		}
+		fn := strings.Replace(in[1], "-", "_", -1)
+		if !mouseFunctions[fn] {
+			return fmt.Errorf("unknown mouse function %q", in[1])
+		}
+		cfg.MouseBinds[fn] = key
 		return nil
 	}},
 
@@ -109,6 +306,18 @@ var parseMap = map[string]parseDecl{
 		return nil
 	}},
 
+	"clickraise": {1, func(cfg *Config, in []string) error {
+		switch in[0] {
+		case "yes":
+			cfg.ClickRaise = true
+		case "no":
+			cfg.ClickRaise = false
+		default:
+			return fmt.Errorf("invalid value %q for clickraise", in[0])
+		}
+		return nil
+	}},
+
 	"color": {2, func(cfg *Config, in []string) error {
 		cfg.Colors[in[0]] = in[1]
 		return nil
@@ -119,6 +328,20 @@ var parseMap = map[string]parseDecl{
 		return nil
 	}},
 
+	"focus": {1, func(cfg *Config, in []string) error {
+		switch in[0] {
+		case "sloppy":
+			cfg.Focus = FocusSloppy
+		case "strict":
+			cfg.Focus = FocusStrict
+		case "click":
+			cfg.Focus = FocusClick
+		default:
+			return fmt.Errorf("invalid focus policy %q", in[0])
+		}
+		return nil
+	}},
+
 	"fontname": {1, func(cfg *Config, in []string) error {
 		cfg.Font = in[0]
 		return nil
@@ -141,24 +364,42 @@ var parseMap = map[string]parseDecl{
 		return nil
 	}},
 
+	"manualplace": {1, func(cfg *Config, in []string) error {
+		cfg.ManualPlace = append(cfg.ManualPlace, in[0])
+		return nil
+	}},
+
+	"mastercount": {1, func(cfg *Config, in []string) error {
+		i, err := strconv.Atoi(in[0])
+		if err != nil {
+			return err
+		}
+		if i < 1 {
+			return fmt.Errorf("invalid master count %d", i)
+		}
+		cfg.MasterCount = i
+		return nil
+	}},
+
+	"masterratio": {1, func(cfg *Config, in []string) error {
+		i, err := strconv.Atoi(in[0])
+		if err != nil {
+			return err
+		}
+		if i < 5 || i > 95 {
+			return fmt.Errorf("master ratio %d not between 5 and 95", i)
+		}
+		cfg.MasterRatio = i
+		return nil
+	}},
+
 	"mousebind": {2, func(cfg *Config, in []string) error {
-		parts := strings.SplitN(in[0], "-", 2)
-		var key KeySpec
-		switch len(parts) {
-		case 1:
-			key = KeySpec{Key: parts[0]}
-		case 2:
-			key = KeySpec{Mods: parts[0], Key: parts[1]}
-		default:
-			return fmt.Errorf("invalid mousepec %q", in[0])
+		key, err := parseMouseSpec(in[0])
+		if err != nil {
+			return err
 		}
 		if in[1] == "unmap" {
-			for k, v := range cfg.MouseBinds {
-				if v == key {
-					delete(cfg.MouseBinds, k)
-					break
-				}
-			}
+			cfg.unbindMouse(key)
 		} else {
 			cfg.MouseBinds[in[1]] = key
 		}
@@ -174,6 +415,32 @@ var parseMap = map[string]parseDecl{
 		return nil
 	}},
 
+	"placement": {1, func(cfg *Config, in []string) error {
+		switch in[0] {
+		case "pointer":
+			cfg.Placement = PlacePointer
+		case "center":
+			cfg.Placement = PlaceCenter
+		case "cascade":
+			cfg.Placement = PlaceCascade
+		case "smart":
+			cfg.Placement = PlaceSmart
+		default:
+			return fmt.Errorf("invalid placement policy %q", in[0])
+		}
+		return nil
+	}},
+
+	"rule": {variadic, func(cfg *Config, in []string) error {
+		r, err := parseRule(in)
+		if err != nil {
+			return err
+		}
+		r.Pos = cfg.pos
+		cfg.Rules = append(cfg.Rules, r)
+		return nil
+	}},
+
 	"snapdist": {1, func(cfg *Config, in []string) error {
 		i, err := strconv.Atoi(in[0])
 		if err != nil {
@@ -194,9 +461,132 @@ var parseMap = map[string]parseDecl{
 		}
 		return nil
 	}},
+
+	"swallow": {1, func(cfg *Config, in []string) error {
+		cfg.Swallow = append(cfg.Swallow, in[0])
+		return nil
+	}},
+
+	"tiling": {1, func(cfg *Config, in []string) error {
+		switch in[0] {
+		case "none":
+			cfg.Tiling = TileNone
+		case "masterstack":
+			cfg.Tiling = TileMasterStack
+		case "monocle":
+			cfg.Tiling = TileMonocle
+		case "grid":
+			cfg.Tiling = TileGrid
+		default:
+			return fmt.Errorf("invalid tiling layout %q", in[0])
+		}
+		return nil
+	}},
+
+	"unbind-key": {1, func(cfg *Config, in []string) error {
+		if in[0] == "all" {
+			cfg.Binds = make(map[KeySpec]string)
+			cfg.keyPos = make(map[KeySpec]Pos)
+			return nil
+		}
+		key, err := parseKeySpec(in[0])
+		if err != nil {
+			return err
+		}
+		delete(cfg.Binds, key)
+		delete(cfg.keyPos, key)
+		return nil
+	}},
+
+	"unbind-mouse": {1, func(cfg *Config, in []string) error {
+		if in[0] == "all" {
+			cfg.MouseBinds = make(map[string]KeySpec)
+			return nil
+		}
+		key, err := parseMouseSpec(in[0])
+		if err != nil {
+			return err
+		}
+		cfg.unbindMouse(key)
+		return nil
+	}},
+}
+
+// unbindMouse removes the binding of key.
+func (cfg *Config) unbindMouse(key KeySpec) {
+	for k, v := range cfg.MouseBinds {
+		if v == key {
+			delete(cfg.MouseBinds, k)
+		}
+	}
+}
+
+// Pos is a position in a configuration file.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Col)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// Error is an error in a configuration file.
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
 }
 
-func Parse(r io.Reader) (*Config, error) {
+// ErrorList is a list of errors, one per line when printed.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Options describe the environment a configuration is parsed for.
+type Options struct {
+	// Function reports whether key bindings may invoke the function
+	// name, in addition to the commands defined with the command
+	// directive. If it is nil, they may only invoke commands.
+	Function func(name string) bool
+	// Monitors is the number of monitors, for sections that depend
+	// on it.
+	Monitors int
+}
+
+// Parse parses a configuration. If there are errors, it returns an
+// ErrorList of all of them, as well as the configuration made from
+// the valid parts of the input.
+func Parse(r io.Reader, opts Options) (*Config, error) {
+	cnt, _ := ioutil.ReadAll(r)
+	return parse("", string(cnt), opts)
+}
+
+// ParseFile is like Parse, but reads the configuration from a file
+// and names the file in errors. Relative includes are relative to the
+// directory of the file, instead of the working directory.
+func ParseFile(path string, opts Options) (*Config, error) {
+	cnt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parse(path, string(cnt), opts)
+}
+
+func newConfig() *Config {
 	cfg := &Config{}
 	cfg.Autogroups = make(map[ClientSpec]int)
 	cfg.Binds = make(map[KeySpec]string)
@@ -204,37 +594,358 @@ func Parse(r io.Reader) (*Config, error) {
 	cfg.Commands = make(map[string]string)
 	cfg.MouseBinds = make(map[string]KeySpec)
 	cfg.MoveAmount = 1
+	cfg.ClickRaise = true
+	cfg.MasterRatio = 50
+	cfg.MasterCount = 1
+	cfg.keyPos = make(map[KeySpec]Pos)
+	return cfg
+}
 
-	cnt, _ := ioutil.ReadAll(r)
-	_, ch := lex(string(cnt))
+// hostname returns the host name, for sections that depend on it.
+var hostname = os.Hostname
+
+var (
+	// varName matches valid variable names, and varRef references
+	// to variables.
+	varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	varRef  = regexp.MustCompile(`\$[A-Za-z_][A-Za-z0-9_]*`)
+)
+
+// state is shared by the parsers of a file and the files it includes.
+type state struct {
+	cfg  *Config
+	errs ErrorList
+	vars map[string]string
+	// files are the files being parsed, innermost last, for
+	// detecting include cycles.
+	files []string
+}
+
+type parser struct {
+	*state
+	file  string
+	items chan item
+	conds []cond
+}
+
+// cond is an if section.
+type cond struct {
+	start  item // the if, for reporting a missing endif
+	active bool // whether the current branch applies
+	done   bool // whether no later branch may apply
+	inElse bool
+}
+
+func (p *parser) next() item {
+	it, ok := <-p.items
+	if !ok {
+		return item{typ: itemEOF}
+	}
+	return it
+}
+
+func (p *parser) pos(it item) Pos {
+	return Pos{File: p.file, Line: it.line, Col: it.col}
+}
+
+func (p *parser) errorf(it item, format string, args ...interface{}) {
+	p.errs = append(p.errs, &Error{p.pos(it), fmt.Sprintf(format, args...)})
+}
+
+// skipLine skips the rest of the line and returns the item that ended
+// it.
+func (p *parser) skipLine() item {
+	for {
+		it := p.next()
+		switch it.typ {
+		case itemTerminator, itemEOF:
+			return it
+		case itemError:
+			p.errorf(it, "%s", it.val)
+			return item{typ: itemEOF}
+		}
+	}
+}
+
+// args reads the num arguments of cmd and the end of the line. It
+// returns the item that ended the line and reports whether the
+// arguments are valid.
+func (p *parser) args(cmd item, num int) ([]string, item, bool) {
+	if num == variadic {
+		return p.restArgs(cmd)
+	}
+	var args []string
+	for len(args) < num {
+		it := p.next()
+		switch it.typ {
+		case itemString:
+			args = append(args, it.val)
+		case itemError:
+			p.errorf(it, "%s", it.val)
+			return nil, item{typ: itemEOF}, false
+		default:
+			p.errorf(cmd, "%s expects %d arguments, got %d", cmd.val, num, len(args))
+			return nil, it, false
+		}
+	}
+	end := p.next()
+	switch end.typ {
+	case itemTerminator, itemEOF:
+		return args, end, true
+	case itemError:
+		p.errorf(end, "%s", end.val)
+		return nil, item{typ: itemEOF}, false
+	default:
+		p.errorf(end, "unexpected %s, expected end of line", end)
+		return nil, p.skipLine(), false
+	}
+}
+
+// restArgs reads the arguments of cmd, which takes any positive
+// number of them, up to the end of the line.
+func (p *parser) restArgs(cmd item) ([]string, item, bool) {
+	var args []string
 	for {
-		command, ok := <-ch
-		if !ok {
-			return cfg, errors.New("internal error")
+		it := p.next()
+		switch it.typ {
+		case itemString:
+			args = append(args, it.val)
+		case itemError:
+			p.errorf(it, "%s", it.val)
+			return nil, item{typ: itemEOF}, false
+		default:
+			if len(args) == 0 {
+				p.errorf(cmd, "%s expects arguments", cmd.val)
+				return nil, it, false
+			}
+			return args, it, true
 		}
+	}
+}
+
+func parse(file, input string, opts Options) (*Config, error) {
+	st := &state{cfg: newConfig(), vars: make(map[string]string)}
+	st.cfg.opts = opts
+	st.parse(file, input)
+	if len(st.errs) > 0 {
+		return st.cfg, st.errs
+	}
+	return st.cfg, nil
+}
+
+// parserDecls are the directives that the parser handles itself,
+// with their number of arguments.
+var parserDecls = map[string]int{
+	"else":    0,
+	"endif":   0,
+	"if":      2,
+	"include": 1,
+	"set":     2,
+}
+
+func (st *state) parse(file, input string) {
+	abs := file
+	if file != "" {
+		abs, _ = filepath.Abs(file)
+	}
+	st.files = append(st.files, abs)
+	defer func() { st.files = st.files[:len(st.files)-1] }()
 
-		if command.typ == itemEOF {
-			return cfg, nil
+	_, ch := lex(input)
+	p := &parser{state: st, file: file, items: ch}
+	for {
+		cmd := p.next()
+		if cmd.typ == itemEOF {
+			break
+		}
+		if cmd.typ == itemTerminator {
+			continue
+		}
+		if cmd.typ == itemError {
+			p.errorf(cmd, "%s", cmd.val)
+			break
 		}
-		if command.typ == itemTerminator {
+		num, builtin := parserDecls[cmd.val]
+		isCond := cmd.val == "if" || cmd.val == "else" || cmd.val == "endif"
+		if !p.active() && !isCond {
+			if p.skipLine().typ == itemEOF {
+				break
+			}
+			continue
+		}
+		decl, ok := parseMap[cmd.val]
+		if !ok && !builtin {
+			p.errorf(cmd, "unknown option %q", cmd.val)
+			if p.skipLine().typ == itemEOF {
+				break
+			}
 			continue
 		}
-		if command.typ != itemString {
-			return cfg, errors.New("unexpected token " + command.String())
+		if !builtin {
+			num = decl.num
+		}
+		in, end, ok := p.args(cmd, num)
+		if ok {
+			for i := range in {
+				in[i] = p.expand(in[i])
+			}
+			if builtin {
+				if err := p.builtin(cmd, in); err != nil {
+					p.errorf(cmd, "%s: %s", cmd.val, err)
+				}
+			} else {
+				st.cfg.pos = p.pos(cmd)
+				if err := decl.fn(st.cfg, in); err != nil {
+					p.errorf(cmd, "%s: %s", cmd.val, err)
+				}
+			}
+		}
+		if end.typ == itemEOF {
+			break
+		}
+	}
+	for _, c := range p.conds {
+		p.errorf(c.start, "if without endif")
+	}
+}
+
+// active reports whether directives currently apply, which they
+// don't in the branches of if sections that don't apply.
+func (p *parser) active() bool {
+	return len(p.conds) == 0 || p.conds[len(p.conds)-1].active
+}
+
+// expand replaces the references to variables in s by their values.
+// References to undefined variables are left alone, so that commands
+// can still use environment variables.
+func (p *parser) expand(s string) string {
+	return varRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if v, ok := p.vars[ref[1:]]; ok {
+			return v
+		}
+		return ref
+	})
+}
+
+// builtin applies one of parserDecls.
+func (p *parser) builtin(cmd item, in []string) error {
+	switch cmd.val {
+	case "if":
+		c := cond{start: cmd, done: true}
+		if p.active() {
+			ok, err := p.eval(in[0], in[1])
+			if err != nil {
+				// Skip the whole section.
+				p.conds = append(p.conds, c)
+				return err
+			}
+			c.active = ok
+			c.done = ok
+		}
+		p.conds = append(p.conds, c)
+	case "else":
+		if len(p.conds) == 0 {
+			return fmt.Errorf("else without if")
 		}
-		decl, ok := parseMap[command.val]
-		if !ok {
-			return cfg, errors.New("unknown option " + command.val)
+		c := &p.conds[len(p.conds)-1]
+		if c.inElse {
+			return fmt.Errorf("more than one else")
+		}
+		c.inElse = true
+		c.active = !c.done
+		c.done = true
+	case "endif":
+		if len(p.conds) == 0 {
+			return fmt.Errorf("endif without if")
+		}
+		p.conds = p.conds[:len(p.conds)-1]
+	case "include":
+		return p.include(in[0])
+	case "set":
+		if !varName.MatchString(in[0]) {
+			return fmt.Errorf("invalid variable name %q", in[0])
+		}
+		p.vars[in[0]] = in[1]
+	}
+	return nil
+}
+
+// eval evaluates the condition of an if section.
+func (p *parser) eval(what, arg string) (bool, error) {
+	switch what {
+	case "hostname":
+		host, err := hostname()
+		if err != nil {
+			return false, err
 		}
-		in, err := expect(ch, decl.num)
+		return path.Match(arg, host)
+	case "monitors":
+		n, err := strconv.Atoi(arg)
 		if err != nil {
-			return cfg, err
+			return false, err
+		}
+		p.cfg.monitors = true
+		return n == p.cfg.opts.Monitors, nil
+	default:
+		return false, fmt.Errorf("unknown condition %q", what)
+	}
+}
+
+// include parses the files matching pattern, in lexical order.
+// Relative patterns are relative to the directory of the including
+// file.
+func (p *parser) include(pattern string) error {
+	if p.file != "" && !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(p.file), pattern)
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 && !strings.ContainsAny(pattern, `*?[\`) {
+		// Not a pattern, so the file has to exist.
+		files = []string{pattern}
+	}
+	for _, file := range files {
+		abs, _ := filepath.Abs(file)
+		for i, f := range p.files {
+			if f == abs {
+				cycle := append(p.files[i:len(p.files):len(p.files)], abs)
+				return fmt.Errorf("include cycle: %s", strings.Join(cycle, " -> "))
+			}
 		}
-		err = decl.fn(cfg, in)
+		cnt, err := ioutil.ReadFile(file)
 		if err != nil {
-			return cfg, err
+			return err
+		}
+		p.state.parse(file, string(cnt))
+	}
+	return nil
+}
+
+// CheckKeys calls valid for the key of every key binding, in the form
+// returned by KeySpec.ToXGB, and returns the errors it reports at the
+// positions of the bindings.
+func (cfg *Config) CheckKeys(valid func(key string) error) error {
+	var errs ErrorList
+	for key := range cfg.Binds {
+		if err := valid(key.ToXGB()); err != nil {
+			errs = append(errs, &Error{cfg.keyPos[key], err.Error()})
 		}
 	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].Pos.File != errs[j].Pos.File {
+			return errs[i].Pos.File < errs[j].Pos.File
+		}
+		if errs[i].Pos.Line != errs[j].Pos.Line {
+			return errs[i].Pos.Line < errs[j].Pos.Line
+		}
+		return errs[i].Pos.Col < errs[j].Pos.Col
+	})
+	return errs
 }
 
 func parseInts(in []string) ([]int, error) {
@@ -249,29 +960,6 @@ func parseInts(in []string) ([]int, error) {
 	return out, nil
 }
 
-func expect(ch chan item, num int) ([]string, error) {
-	var ret []string
-	for i := 0; i < num; i++ {
-		val := <-ch
-		if val.typ == itemError {
-			return ret, errors.New(val.val)
-		}
-
-		if val.typ == itemTerminator || val.typ == itemEOF {
-			return ret, io.EOF
-		}
-
-		ret = append(ret, val.val)
-	}
-
-	val := <-ch
-	if val.typ != itemTerminator {
-		return ret, errors.New("unexpected token " + val.typ.String())
-	}
-
-	return ret, nil
-}
-
 type lexer struct {
 	input             string
 	start             int
@@ -307,8 +995,10 @@ func (i itemType) String() string {
 const eof = -1
 
 type item struct {
-	typ itemType
-	val string
+	typ  itemType
+	val  string
+	line int
+	col  int
 }
 
 func (i item) String() string {
@@ -340,11 +1030,26 @@ func (l *lexer) run() {
 }
 
 func (l *lexer) emit(t itemType) {
+	l.emitValue(t, l.input[l.start:l.pos])
+}
+
+// emitValue emits an item whose value differs from the input, for
+// example because escapes have been processed.
+func (l *lexer) emitValue(t itemType, val string) {
 	l.lastWasTerminator = t == itemTerminator
-	l.items <- item{t, l.input[l.start:l.pos]}
+	line, col := l.position(l.start)
+	l.items <- item{t, val, line, col}
 	l.start = l.pos
 }
 
+// position returns the line and column, both starting at 1, of the
+// byte offset in the input.
+func (l *lexer) position(offset int) (line, col int) {
+	line = 1 + strings.Count(l.input[:offset], "\n")
+	lineStart := strings.LastIndex(l.input[:offset], "\n") + 1
+	return line, 1 + utf8.RuneCountInString(l.input[lineStart:offset])
+}
+
 func (l *lexer) next() (rune rune) {
 	if l.pos >= len(l.input) {
 		l.width = 0
@@ -384,101 +1089,100 @@ func (l *lexer) acceptRun(valid string) {
 }
 
 func (l *lexer) errorf(format string, args ...interface{}) stateFn {
-	l.items <- item{itemError, fmt.Sprintf(format, args...)}
+	line, col := l.position(l.start)
+	l.items <- item{itemError, fmt.Sprintf(format, args...), line, col}
 	return nil
 }
 
 func lexText(l *lexer) stateFn {
 	for {
 		r := l.next()
-		if r == eof {
-			break
-		}
-
-		if r == '#' {
+		switch {
+		case r == eof:
+			l.emit(itemEOF)
+			return nil
+		case r == '#':
 			return lexComment
-		}
-
-		// TODO is this right?
-		if r == ' ' || r == '\t' {
+		case r == ' ' || r == '\t' || r == '\r':
 			l.ignore()
-			continue
-		}
-
-		if r == '\n' {
+		case r == '\\' && l.peek() == '\n':
+			// A backslash at the end of a line continues the
+			// directive on the next line.
+			l.next()
+			l.ignore()
+		case r == '\n':
 			if l.lastWasTerminator {
 				l.ignore()
 			} else {
 				l.emit(itemTerminator)
 			}
+		case r == '"':
+			return lexQuoted
+		default:
+			l.backup()
+			return lexWord
 		}
-
-		return lexString
-
 	}
-	l.emit(itemEOF)
-	return nil
 }
 
-func lexString(l *lexer) stateFn {
-	quoted := false
-	defer func() {
-		if l.input[l.start:l.pos] != "" {
-			if quoted {
-				l.start++
-				l.pos--
-			}
-			l.emit(itemString)
-			if quoted {
-				l.pos++
-				l.start = l.pos
+// lexWord lexes an unquoted string. Like in cwm, a backslash followed
+// by a newline joins the two lines, and other backslashes are kept
+// as they are.
+func lexWord(l *lexer) stateFn {
+	var val strings.Builder
+	for {
+		r := l.next()
+		switch r {
+		case '\\':
+			switch n := l.next(); n {
+			case '\n':
+				// Line continuation.
+			case eof:
+				val.WriteRune(r)
+			default:
+				val.WriteRune(r)
+				val.WriteRune(n)
 			}
+		case eof, ' ', '\t', '\r', '\n', '#':
+			l.backup()
+			l.emitValue(itemString, val.String())
+			return lexText
+		default:
+			val.WriteRune(r)
 		}
-	}()
-	if l.input[l.pos-1] == '"' {
-		quoted = true
 	}
-	escape := false
-	multiline := false
+}
 
-	var r rune
-loop:
-	for r != eof {
-		r = l.next()
+// lexQuoted lexes a quoted string, after the opening quote. Inside
+// quotes, \" and \\ are escapes for a quote and a backslash, a
+// backslash followed by a newline joins the two lines, and # doesn't
+// start a comment.
+func lexQuoted(l *lexer) stateFn {
+	var val strings.Builder
+	for {
+		r := l.next()
 		switch r {
+		case eof:
+			return l.errorf("unterminated quoted string")
 		case '\\':
-			if quoted {
-				escape = !escape
-			} else {
-				multiline = true
+			switch n := l.next(); n {
+			case '"', '\\':
+				val.WriteRune(n)
+			case '\n':
+				// Line continuation.
+			case eof:
+				return l.errorf("unterminated quoted string")
+			default:
+				val.WriteRune(r)
+				val.WriteRune(n)
 			}
-			// TODO multiline string
 		case '"':
-			if quoted && !escape {
-				break loop
-			}
-		case ' ', '\t':
-			if !quoted {
-				l.backup()
-				break loop
-			}
-		case '\n':
-			if quoted || multiline {
-				multiline = false
-			} else {
-				l.backup()
-				break loop
-			}
-		case '#':
-			if !quoted {
-				l.backup()
-
-				return lexComment
-			}
+			l.emitValue(itemString, val.String())
+			return lexText
+		default:
+			val.WriteRune(r)
 		}
 	}
-
-	return lexText
 }
 
 func lexComment(l *lexer) stateFn {
@@ -0,0 +1,432 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func lexAll(input string) []item {
+	_, ch := lex(input)
+	var items []item
+	for it := range ch {
+		// Positions are tested separately.
+		it.line, it.col = 0, 0
+		items = append(items, it)
+	}
+	return items
+}
+
+func TestLex(t *testing.T) {
+	var (
+		term = item{typ: itemTerminator, val: "\n"}
+		eof  = item{typ: itemEOF}
+	)
+	str := func(s string) item { return item{typ: itemString, val: s} }
+
+	var tests = []struct {
+		in  string
+		out []item
+	}{
+		{"", []item{eof}},
+		{"sticky yes\n", []item{str("sticky"), str("yes"), term, eof}},
+		{"  sticky\tyes  \r\n", []item{str("sticky"), str("yes"), term, eof}},
+		{"sticky yes # comment\n", []item{str("sticky"), str("yes"), term, eof}},
+		{"# comment\n\n\nsticky yes", []item{term, str("sticky"), str("yes"), eof}},
+		{`fontname "sans serif"`, []item{str("fontname"), str("sans serif"), eof}},
+		{`command x "xterm -T '#1'"`, []item{str("command"), str("x"), str("xterm -T '#1'"), eof}},
+		{`command x "say \"hi\""`, []item{str("command"), str("x"), str(`say "hi"`), eof}},
+		{`command x "a\\b"`, []item{str("command"), str("x"), str(`a\b`), eof}},
+		{`command x "a\nb"`, []item{str("command"), str("x"), str(`a\nb`), eof}},
+		{"command x \\\n  xterm", []item{str("command"), str("x"), str("xterm"), eof}},
+		{"command x \"xterm \\\n-e top\"", []item{str("command"), str("x"), str("xterm -e top"), eof}},
+		{"command x xte\\\nrm", []item{str("command"), str("x"), str("xterm"), eof}},
+		{`command x C:\x`, []item{str("command"), str("x"), str(`C:\x`), eof}},
+		{`command x printf\t%s\n`, []item{str("command"), str("x"), str(`printf\t%s\n`), eof}},
+		{`ignore ""`, []item{str("ignore"), str(""), eof}},
+		{`fontname "sans`, []item{str("fontname"), {typ: itemError, val: "unterminated quoted string"}}},
+	}
+	for _, tt := range tests {
+		if out := lexAll(tt.in); !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("lex(%q) = %v, want %v", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestLexPosition(t *testing.T) {
+	_, ch := lex("sticky yes\n  fontname \"sans\"\n")
+	var tests = []struct {
+		val       string
+		line, col int
+	}{
+		{"sticky", 1, 1},
+		{"yes", 1, 8},
+		{"\n", 1, 11},
+		{"fontname", 2, 3},
+		{"sans", 2, 12},
+	}
+	for _, tt := range tests {
+		it := <-ch
+		if it.val != tt.val || it.line != tt.line || it.col != tt.col {
+			t.Errorf("got %q at %d:%d, want %q at %d:%d", it.val, it.line, it.col, tt.val, tt.line, tt.col)
+		}
+	}
+	for range ch {
+	}
+}
+
+// cwmrc is a configuration in the style of existing cwmrc files.
+const cwmrc = `# cwmrc
+fontname "sans-serif:pixelsize=14:bold"
+borderwidth 2
+snapdist 4
+moveamount 10
+sticky yes
+gap 20 0 0 0
+
+color activeborder "#ff0000"
+color inactiveborder gray
+
+command term xterm
+command top "xterm -e top"
+command lock "xlock -mode blank"
+
+autogroup 3 "xterm"
+autogroup 4 "Firefox.Navigator"
+ignore xclock
+
+bind CM-Return terminal
+bind 4-h top
+bind M-Tab unmap
+mousebind M-1 window_move
+`
+
+func TestParse(t *testing.T) {
+	opts := Options{Function: func(name string) bool { return name == "terminal" }}
+	cfg, err := Parse(strings.NewReader(cwmrc), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Font != "sans-serif:pixelsize=14:bold" {
+		t.Errorf("Font = %q", cfg.Font)
+	}
+	if cfg.BorderWidth != 2 || cfg.Snapdist != 4 || cfg.MoveAmount != 10 || !cfg.Sticky {
+		t.Errorf("BorderWidth, Snapdist, MoveAmount, Sticky = %d, %d, %d, %t",
+			cfg.BorderWidth, cfg.Snapdist, cfg.MoveAmount, cfg.Sticky)
+	}
+	if want := (Gap{Top: 20}); cfg.Gap != want {
+		t.Errorf("Gap = %v, want %v", cfg.Gap, want)
+	}
+	if want := map[string]string{"activeborder": "#ff0000", "inactiveborder": "gray"}; !reflect.DeepEqual(cfg.Colors, want) {
+		t.Errorf("Colors = %v, want %v", cfg.Colors, want)
+	}
+	wantCommands := map[string]string{
+		"term": "xterm",
+		"top":  "xterm -e top",
+		"lock": "xlock -mode blank",
+	}
+	if !reflect.DeepEqual(cfg.Commands, wantCommands) {
+		t.Errorf("Commands = %v, want %v", cfg.Commands, wantCommands)
+	}
+	wantGroups := map[ClientSpec]int{
+		{Class: "xterm"}:                      3,
+		{Name: "Firefox", Class: "Navigator"}: 4,
+	}
+	if !reflect.DeepEqual(cfg.Autogroups, wantGroups) {
+		t.Errorf("Autogroups = %v, want %v", cfg.Autogroups, wantGroups)
+	}
+	if want := []string{"xclock"}; !reflect.DeepEqual(cfg.Ignores, want) {
+		t.Errorf("Ignores = %v, want %v", cfg.Ignores, want)
+	}
+	wantBinds := map[KeySpec]string{
+		{Mods: "CM", Key: "Return"}: "terminal",
+		{Mods: "4", Key: "h"}:       "top",
+	}
+	if !reflect.DeepEqual(cfg.Binds, wantBinds) {
+		t.Errorf("Binds = %v, want %v", cfg.Binds, wantBinds)
+	}
+	if want := (KeySpec{Mods: "M", Key: "1"}); cfg.MouseBinds["window_move"] != want {
+		t.Errorf("MouseBinds[window_move] = %v, want %v", cfg.MouseBinds["window_move"], want)
+	}
+}
+
+func TestParseContinuation(t *testing.T) {
+	var tests = []struct {
+		split, joined string
+	}{
+		{
+			"command mail \\\n\t\"xterm -e mutt\"\n",
+			"command mail \"xterm -e mutt\"\n",
+		},
+		{
+			"command mail \"xterm \\\n-e mutt\"\n",
+			"command mail \"xterm -e mutt\"\n",
+		},
+		{
+			"gap 10 \\\n  0 \\\n  0 \\\n  0\n",
+			"gap 10 0 0 0\n",
+		},
+	}
+	for _, tt := range tests {
+		split, err := Parse(strings.NewReader(tt.split), Options{})
+		if err != nil {
+			t.Errorf("Parse(%q): %s", tt.split, err)
+			continue
+		}
+		joined, err := Parse(strings.NewReader(tt.joined), Options{})
+		if err != nil {
+			t.Errorf("Parse(%q): %s", tt.joined, err)
+			continue
+		}
+		if !reflect.DeepEqual(split.Commands, joined.Commands) || split.Gap != joined.Gap {
+			t.Errorf("Parse(%q) = %v, %v, want %v, %v", tt.split, split.Commands, split.Gap, joined.Commands, joined.Gap)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var tests = []struct {
+		in  string
+		err string
+	}{
+		{"foo bar\n", `1:1: unknown option "foo"`},
+		{"sticky yes\nsnapdist x\n", `2:1: snapdist: strconv.Atoi: parsing "x": invalid syntax`},
+		{"gap 1 2\n", "1:1: gap expects 4 arguments, got 2"},
+		{"sticky yes no\n", `1:12: unexpected (string) "no", expected end of line`},
+		{"foo\nsticky maybe\n", "1:1: unknown option \"foo\"\n2:1: sticky: invalid value \"maybe\" for sticky"},
+		{"fontname \"sans\n", "1:10: unterminated quoted string"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(strings.NewReader(tt.in), Options{})
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want error %q", tt.in, tt.err)
+			continue
+		}
+		if err.Error() != tt.err {
+			t.Errorf("Parse(%q) = %q, want %q", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestParseBindings(t *testing.T) {
+	opts := Options{Function: func(name string) bool { return name == "terminal" || name == "lock" }}
+	cfg, err := Parse(strings.NewReader(`command top "xterm -e top"
+bind CM-Return terminal
+bind 4-h top
+unbind-key all
+bind-key CM-Enter terminal
+bind-key 2S-Esc top
+bind-key 5-PgUp lock
+unbind-key 5-Prior
+mousebind M-1 window_move
+unbind-mouse all
+bind-mouse 3-1 window-resize
+bind-mouse M-2 window-lower
+unbind-mouse M-2
+`), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBinds := map[KeySpec]string{
+		{Mods: "CM", Key: "Return"}: "terminal",
+		{Mods: "2S", Key: "Escape"}: "top",
+	}
+	if !reflect.DeepEqual(cfg.Binds, wantBinds) {
+		t.Errorf("Binds = %v, want %v", cfg.Binds, wantBinds)
+	}
+	wantMouse := map[string]KeySpec{"window_resize": {Mods: "3", Key: "1"}}
+	if !reflect.DeepEqual(cfg.MouseBinds, wantMouse) {
+		t.Errorf("MouseBinds = %v, want %v", cfg.MouseBinds, wantMouse)
+	}
+}
+
+func TestParseBindingErrors(t *testing.T) {
+	var tests = []struct {
+		in  string
+		err string
+	}{
+		{"bind X-a terminal\n", `1:1: bind: invalid modifier 'X' in "X-a"`},
+		{"bind C- terminal\n", `1:1: bind: missing key in "C-"`},
+		{"bind M-x nosuchcmd\n", `1:1: bind: unknown function "nosuchcmd"`},
+		{"bind-key M-a nosuchfunction\n", `1:1: bind-key: unknown function "nosuchfunction"`},
+		{"bind-key M-a top\ncommand top xterm\n", `1:1: bind-key: unknown function "top"`},
+		{"unbind-key Q-a\n", `1:1: unbind-key: invalid modifier 'Q' in "Q-a"`},
+		{"bind-mouse M-x window-move\n", `1:1: bind-mouse: invalid mouse button "x"`},
+		{"bind-mouse M-1 window-raise\n", `1:1: bind-mouse: unknown mouse function "window-raise"`},
+		{"mousebind 6-1 window_move\n", `1:1: mousebind: invalid modifier '6' in "6-1"`},
+	}
+	for _, tt := range tests {
+		_, err := Parse(strings.NewReader(tt.in), Options{})
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want error %q", tt.in, tt.err)
+			continue
+		}
+		if err.Error() != tt.err {
+			t.Errorf("Parse(%q) = %q, want %q", tt.in, err, tt.err)
+		}
+	}
+}
+
+// writeFiles writes files, relative to a new temporary directory, and
+// returns the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "gwm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, cnt := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(cnt), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestParseInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"cwmrc":         "borderwidth 1\ninclude base\ninclude conf.d/*.conf\n",
+		"base":          "borderwidth 2\nsnapdist 3\n",
+		"conf.d/a.conf": "snapdist 4\n",
+		"conf.d/b.conf": "snapdist 5\ngap 1 1 1 1\n",
+		"conf.d/c.txt":  "snapdist 6\n",
+	})
+	defer os.RemoveAll(dir)
+
+	cfg, err := ParseFile(filepath.Join(dir, "cwmrc"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BorderWidth != 2 || cfg.Snapdist != 5 || cfg.Gap != (Gap{1, 1, 1, 1}) {
+		t.Errorf("BorderWidth, Snapdist, Gap = %d, %d, %v, want 2, 5, {1 1 1 1}", cfg.BorderWidth, cfg.Snapdist, cfg.Gap)
+	}
+}
+
+func TestParseIncludeErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"cwmrc":   "include a\ninclude missing\ninclude none/*\n",
+		"a":       "sticky yes\ninclude sub/b\n",
+		"sub/b":   "sticky maybe\ninclude ../a\n",
+		"invalid": "include [\n",
+	})
+	defer os.RemoveAll(dir)
+
+	rc := filepath.Join(dir, "cwmrc")
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "sub", "b")
+	want := strings.Join([]string{
+		b + `:1:1: sticky: invalid value "maybe" for sticky`,
+		b + ":2:1: include: include cycle: " + a + " -> " + b + " -> " + a,
+		rc + ":2:1: include: open " + filepath.Join(dir, "missing") + ": no such file or directory",
+	}, "\n")
+	_, err := ParseFile(rc, Options{})
+	if err == nil || err.Error() != want {
+		t.Errorf("ParseFile(cwmrc) = %v, want %s", err, want)
+	}
+
+	want = filepath.Join(dir, "invalid") + ":1:1: include: syntax error in pattern"
+	_, err = ParseFile(filepath.Join(dir, "invalid"), Options{})
+	if err == nil || err.Error() != want {
+		t.Errorf("ParseFile(invalid) = %v, want %s", err, want)
+	}
+}
+
+func TestParseVariables(t *testing.T) {
+	cfg, err := Parse(strings.NewReader(`set term "xterm -bg black"
+set width 3
+command top "$term -e top"
+command shell "$term -e sh -c 'echo $HOME'"
+borderwidth $width
+set width 4
+snapdist $width
+`), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCommands := map[string]string{
+		"top":   "xterm -bg black -e top",
+		"shell": "xterm -bg black -e sh -c 'echo $HOME'",
+	}
+	if !reflect.DeepEqual(cfg.Commands, wantCommands) {
+		t.Errorf("Commands = %v, want %v", cfg.Commands, wantCommands)
+	}
+	if cfg.BorderWidth != 3 || cfg.Snapdist != 4 {
+		t.Errorf("BorderWidth, Snapdist = %d, %d, want 3, 4", cfg.BorderWidth, cfg.Snapdist)
+	}
+
+	_, err = Parse(strings.NewReader("set 1x y\n"), Options{})
+	if want := `1:1: set: invalid variable name "1x"`; err == nil || err.Error() != want {
+		t.Errorf("Parse(set 1x y) = %v, want %s", err, want)
+	}
+}
+
+func TestParseConditionals(t *testing.T) {
+	hostname = func() (string, error) { return "laptop-1", nil }
+	defer func() { hostname = os.Hostname }()
+
+	cfg, err := Parse(strings.NewReader(`borderwidth 1
+if hostname laptop-*
+	borderwidth 2
+	if monitors 1
+		snapdist 1
+	else
+		snapdist 2
+		if monitors 2
+			moveamount 5
+		endif
+	endif
+else
+	borderwidth 3
+	bogus directive
+	if monitors 2
+		snapdist 9
+	else
+		snapdist 9
+	endif
+endif
+if hostname desktop
+	sticky yes
+endif
+`), Options{Monitors: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BorderWidth != 2 || cfg.Snapdist != 2 || cfg.MoveAmount != 5 || cfg.Sticky {
+		t.Errorf("BorderWidth, Snapdist, MoveAmount, Sticky = %d, %d, %d, %t, want 2, 2, 5, false",
+			cfg.BorderWidth, cfg.Snapdist, cfg.MoveAmount, cfg.Sticky)
+	}
+	if !cfg.DependsOnMonitors() {
+		t.Error("DependsOnMonitors() = false, want true")
+	}
+
+	var tests = []struct {
+		in  string
+		err string
+	}{
+		{"else\n", "1:1: else: else without if"},
+		{"endif\n", "1:1: endif: endif without if"},
+		{"if monitors 1\nelse\nelse\nendif\n", "3:1: else: more than one else"},
+		{"if monitors 1\n", "1:1: if without endif"},
+		{"if weather sunny\nsticky yes\nendif\n", `1:1: if: unknown condition "weather"`},
+		{"if monitors many\nendif\n", `1:1: if: strconv.Atoi: parsing "many": invalid syntax`},
+	}
+	for _, tt := range tests {
+		_, err := Parse(strings.NewReader(tt.in), Options{})
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("Parse(%q) = %v, want %q", tt.in, err, tt.err)
+		}
+	}
+}
+
+func TestParseFileErrors(t *testing.T) {
+	_, err := parse("cwmrc", "sticky maybe\n", Options{})
+	if want := `cwmrc:1:1: sticky: invalid value "maybe" for sticky`; err == nil || err.Error() != want {
+		t.Errorf("parse = %v, want %s", err, want)
+	}
+}
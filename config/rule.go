@@ -0,0 +1,177 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Layer is the stacking layer a rule puts windows in.
+type Layer int
+
+const (
+	// LayerDefault leaves the layer alone.
+	LayerDefault Layer = iota
+	LayerBelow
+	LayerNormal
+	LayerAbove
+)
+
+// Geometry is an X geometry specification of the form WxH+X+Y, in
+// which either the size or the position may be omitted. Negative
+// offsets are from the right and bottom edges.
+type Geometry struct {
+	Width, Height int // 0 if the size is omitted
+	X, Y          int
+	XNeg, YNeg    bool
+	Position      bool // whether the position was given
+}
+
+var geometryRe = regexp.MustCompile(`^(?:(\d+)x(\d+))?(?:([+-]\d+)([+-]\d+))?$`)
+
+func parseGeometry(s string) (Geometry, error) {
+	m := geometryRe.FindStringSubmatch(s)
+	if s == "" || m == nil {
+		return Geometry{}, fmt.Errorf("invalid geometry %q", s)
+	}
+	var g Geometry
+	if m[1] != "" {
+		g.Width, _ = strconv.Atoi(m[1])
+		g.Height, _ = strconv.Atoi(m[2])
+		if g.Width == 0 || g.Height == 0 {
+			return g, fmt.Errorf("invalid geometry %q", s)
+		}
+	}
+	if m[3] != "" {
+		g.Position = true
+		g.X, _ = strconv.Atoi(m[3][1:])
+		g.Y, _ = strconv.Atoi(m[4][1:])
+		g.XNeg = m[3][0] == '-'
+		g.YNeg = m[4][0] == '-'
+	}
+	return g, nil
+}
+
+// Rule sets properties of the windows it matches, when they are
+// first mapped. Empty criteria match every window.
+type Rule struct {
+	Pos Pos
+
+	Client ClientSpec     // WM_CLASS
+	Title  *regexp.Regexp // the window's name
+	Role   string         // WM_WINDOW_ROLE
+	Type   string         // a _NET_WM_WINDOW_TYPE
+
+	BorderWidth *int
+	Layer       Layer
+	Geometry    *Geometry
+	Frozen      bool
+	Fullscreen  bool
+	SkipSearch  bool
+	NoFocus     bool
+}
+
+// Matches reports whether the rule matches a window with the given
+// WM_CLASS, name, WM_WINDOW_ROLE and window types.
+func (r *Rule) Matches(instance, class, title, role string, types []string) bool {
+	if r.Client.Name != "" && r.Client.Name != instance {
+		return false
+	}
+	if r.Client.Class != "" && r.Client.Class != class {
+		return false
+	}
+	if r.Title != nil && !r.Title.MatchString(title) {
+		return false
+	}
+	if r.Role != "" && r.Role != role {
+		return false
+	}
+	if r.Type == "" {
+		return true
+	}
+	for _, typ := range types {
+		if typ == r.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRule parses the words of a rule directive, criteria and
+// settings of the form key=value, and flags.
+func parseRule(in []string) (Rule, error) {
+	var r Rule
+	settings := 0
+	for _, word := range in {
+		key, val := word, ""
+		if i := strings.Index(word, "="); i >= 0 {
+			key, val = word[:i], word[i+1:]
+		}
+		var err error
+		switch key {
+		case "class":
+			r.Client.Class = val
+		case "instance":
+			r.Client.Name = val
+		case "title":
+			r.Title, err = regexp.Compile(val)
+		case "role":
+			r.Role = val
+		case "type":
+			r.Type = strings.ToUpper(val)
+			if !strings.HasPrefix(r.Type, "_NET_WM_WINDOW_TYPE_") {
+				r.Type = "_NET_WM_WINDOW_TYPE_" + r.Type
+			}
+		case "borderwidth":
+			var bw int
+			bw, err = strconv.Atoi(val)
+			if err == nil && bw < 0 {
+				err = fmt.Errorf("invalid border width %d", bw)
+			}
+			r.BorderWidth = &bw
+			settings++
+		case "layer":
+			switch val {
+			case "below":
+				r.Layer = LayerBelow
+			case "normal":
+				r.Layer = LayerNormal
+			case "above":
+				r.Layer = LayerAbove
+			default:
+				err = fmt.Errorf("invalid layer %q", val)
+			}
+			settings++
+		case "geometry":
+			var g Geometry
+			g, err = parseGeometry(val)
+			r.Geometry = &g
+			settings++
+		case "frozen", "fullscreen", "skipsearch", "nofocus":
+			if val != "" {
+				return r, fmt.Errorf("%s doesn't take a value", key)
+			}
+			switch key {
+			case "frozen":
+				r.Frozen = true
+			case "fullscreen":
+				r.Fullscreen = true
+			case "skipsearch":
+				r.SkipSearch = true
+			case "nofocus":
+				r.NoFocus = true
+			}
+			settings++
+		default:
+			return r, fmt.Errorf("unknown rule setting %q", key)
+		}
+		if err != nil {
+			return r, err
+		}
+	}
+	if settings == 0 {
+		return r, fmt.Errorf("rule doesn't set anything")
+	}
+	return r, nil
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseGeometry(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out Geometry
+	}{
+		{"800x600", Geometry{Width: 800, Height: 600}},
+		{"+10+20", Geometry{X: 10, Y: 20, Position: true}},
+		{"800x600-0+20", Geometry{Width: 800, Height: 600, X: 0, Y: 20, XNeg: true, Position: true}},
+		{"1x1-5-6", Geometry{Width: 1, Height: 1, X: 5, Y: 6, XNeg: true, YNeg: true, Position: true}},
+	}
+	for _, tt := range tests {
+		out, err := parseGeometry(tt.in)
+		if err != nil {
+			t.Errorf("parseGeometry(%q): %s", tt.in, err)
+			continue
+		}
+		if out != tt.out {
+			t.Errorf("parseGeometry(%q) = %+v, want %+v", tt.in, out, tt.out)
+		}
+	}
+	for _, in := range []string{"", "800", "800x", "0x600", "+10", "800x600+10", "x+1+1"} {
+		if _, err := parseGeometry(in); err == nil {
+			t.Errorf("parseGeometry(%q) succeeded, want error", in)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	cfg, err := Parse(strings.NewReader(`rule class=Firefox role=browser layer=above geometry=1200x800+0+0
+rule "title=^Picture-in-Picture$" type=utility frozen skipsearch nofocus
+rule instance=scratch type=_NET_WM_WINDOW_TYPE_DIALOG borderwidth=0 fullscreen
+`), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Rules) != 3 {
+		t.Fatalf("got %d rules, want 3", len(cfg.Rules))
+	}
+
+	r := cfg.Rules[0]
+	if r.Client != (ClientSpec{Class: "Firefox"}) || r.Role != "browser" || r.Layer != LayerAbove {
+		t.Errorf("rule 1 = %+v", r)
+	}
+	if r.Geometry == nil || *r.Geometry != (Geometry{Width: 1200, Height: 800, Position: true}) {
+		t.Errorf("rule 1 geometry = %+v", r.Geometry)
+	}
+	if r.Pos.Line != 1 {
+		t.Errorf("rule 1 is at line %d, want 1", r.Pos.Line)
+	}
+
+	r = cfg.Rules[1]
+	if r.Title == nil || r.Type != "_NET_WM_WINDOW_TYPE_UTILITY" || !r.Frozen || !r.SkipSearch || !r.NoFocus || r.Fullscreen {
+		t.Errorf("rule 2 = %+v", r)
+	}
+
+	r = cfg.Rules[2]
+	if r.Client != (ClientSpec{Name: "scratch"}) || r.Type != "_NET_WM_WINDOW_TYPE_DIALOG" ||
+		r.BorderWidth == nil || *r.BorderWidth != 0 || !r.Fullscreen || r.Layer != LayerDefault {
+		t.Errorf("rule 3 = %+v", r)
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	cfg, err := Parse(strings.NewReader(`rule class=Firefox instance=Navigator "title=Mozilla Firefox$" role=browser type=normal frozen
+rule frozen
+`), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, all := cfg.Rules[0], cfg.Rules[1]
+	normal := []string{"_NET_WM_WINDOW_TYPE_NORMAL"}
+
+	var tests = []struct {
+		instance, class, title, role string
+		types                        []string
+		match                        bool
+	}{
+		{"Navigator", "Firefox", "Example - Mozilla Firefox", "browser", normal, true},
+		{"Navigator", "Firefox", "Example - Mozilla Firefox", "browser", []string{"_NET_WM_WINDOW_TYPE_DIALOG", "_NET_WM_WINDOW_TYPE_NORMAL"}, true},
+		{"Toolkit", "Firefox", "Example - Mozilla Firefox", "browser", normal, false},
+		{"Navigator", "firefox", "Example - Mozilla Firefox", "browser", normal, false},
+		{"Navigator", "Firefox", "Mozilla Firefox Private Browsing", "browser", normal, false},
+		{"Navigator", "Firefox", "Example - Mozilla Firefox", "", normal, false},
+		{"Navigator", "Firefox", "Example - Mozilla Firefox", "browser", []string{"_NET_WM_WINDOW_TYPE_DIALOG"}, false},
+	}
+	for _, tt := range tests {
+		if got := r.Matches(tt.instance, tt.class, tt.title, tt.role, tt.types); got != tt.match {
+			t.Errorf("Matches(%q, %q, %q, %q, %v) = %t, want %t", tt.instance, tt.class, tt.title, tt.role, tt.types, got, tt.match)
+		}
+		if !all.Matches(tt.instance, tt.class, tt.title, tt.role, tt.types) {
+			t.Errorf("rule without criteria doesn't match %q", tt.title)
+		}
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	var tests = []struct {
+		in  string
+		err string
+	}{
+		{"rule\n", "1:1: rule expects arguments"},
+		{"rule class=xterm\n", "1:1: rule: rule doesn't set anything"},
+		{"rule class=xterm sticky\n", `1:1: rule: unknown rule setting "sticky"`},
+		{"rule class=xterm frozen=yes\n", "1:1: rule: frozen doesn't take a value"},
+		{"rule class=xterm layer=top\n", `1:1: rule: invalid layer "top"`},
+		{"rule class=xterm borderwidth=-1\n", "1:1: rule: invalid border width -1"},
+		{"rule class=xterm geometry=big\n", `1:1: rule: invalid geometry "big"`},
+		{"rule title=( frozen\n", "1:1: rule: error parsing regexp: missing closing ): `(`"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(strings.NewReader(tt.in), Options{})
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want error %q", tt.in, tt.err)
+			continue
+		}
+		if err.Error() != tt.err {
+			t.Errorf("Parse(%q) = %q, want %q", tt.in, err, tt.err)
+		}
+	}
+}
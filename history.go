@@ -0,0 +1,99 @@
+package main
+
+// maxTransactions is the number of transactions that are kept.
+const maxTransactions = 10
+
+// change is the layout a window had before a transaction changed it,
+// and the length its layout stack had then.
+type change struct {
+	win    *Window
+	layout Layout
+	stack  int
+}
+
+// transaction groups changes to several windows, so that they can be
+// undone in one step.
+type transaction struct {
+	// changes are the layouts of the windows whose layouts were
+	// pushed, from before their first push.
+	changes []change
+	// undo, if set, reverts changes that aren't part of the windows'
+	// layouts.
+	undo func()
+}
+
+// beginTransaction starts recording the layouts of the windows whose
+// layouts are pushed, until endTransaction is called.
+func (wm *WM) beginTransaction() {
+	wm.txn = &transaction{}
+}
+
+// endTransaction stops recording and adds the transaction to the
+// log. undo may be nil. It returns the transaction, or nil if there
+// was nothing to record.
+func (wm *WM) endTransaction(undo func()) *transaction {
+	t := wm.txn
+	wm.txn = nil
+	if t == nil || (len(t.changes) == 0 && undo == nil) {
+		return nil
+	}
+	t.undo = undo
+	wm.transactions = append(wm.transactions, t)
+	if len(wm.transactions) > maxTransactions {
+		copy(wm.transactions, wm.transactions[1:])
+		wm.transactions = wm.transactions[:len(wm.transactions)-1]
+	}
+	return t
+}
+
+// forgetTransaction removes t from the log, for when what it would
+// undo has been undone in a different way.
+func (wm *WM) forgetTransaction(t *transaction) {
+	for i, ot := range wm.transactions {
+		if ot == t {
+			wm.transactions = append(wm.transactions[:i], wm.transactions[i+1:]...)
+			return
+		}
+	}
+}
+
+// record adds win's current layout to the current transaction, unless
+// the transaction already has an earlier one. It must be called before
+// the layout is pushed.
+func (wm *WM) record(win *Window) {
+	if wm.txn == nil {
+		return
+	}
+	for _, c := range wm.txn.changes {
+		if c.win == win {
+			return
+		}
+	}
+	wm.txn.changes = append(wm.txn.changes, change{win, win.Layout, len(win.LayoutStack)})
+}
+
+// UndoAll undoes the most recent transaction, restoring the layouts
+// and layout stacks all windows it affected had before it. Undoing
+// them can be redone per window with RedoLayout.
+func (wm *WM) UndoAll() {
+	if len(wm.transactions) == 0 {
+		return
+	}
+	t := wm.transactions[len(wm.transactions)-1]
+	wm.transactions = wm.transactions[:len(wm.transactions)-1]
+	if t.undo != nil {
+		t.undo()
+	}
+	for _, c := range t.changes {
+		win := c.win
+		if wm.Windows[win.Id] != win {
+			// The window has been destroyed since.
+			continue
+		}
+		if len(win.LayoutStack) > c.stack {
+			win.LayoutStack = win.LayoutStack[:c.stack]
+		}
+		win.RedoStack = pushLayout(win.RedoStack, win.Layout)
+		win.ApplyLayout(c.layout)
+	}
+}
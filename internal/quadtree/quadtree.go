@@ -97,6 +97,39 @@ func (n *Node) HasValue(r Region, value int) bool {
 	return b
 }
 
+// Area returns the area of the part of r that is covered by nodes
+// with the given value.
+func (n *Node) Area(r Region, value int) int {
+	if !n.overlaps(r) {
+		return 0
+	}
+	if !n.isSplit {
+		if n.Value != value {
+			return 0
+		}
+		x1, y1 := n.X, n.Y
+		x2, y2 := n.X+n.Size, n.Y+n.Size
+		if r.X > x1 {
+			x1 = r.X
+		}
+		if r.Y > y1 {
+			y1 = r.Y
+		}
+		if r.X+r.Width < x2 {
+			x2 = r.X + r.Width
+		}
+		if r.Y+r.Height < y2 {
+			y2 = r.Y + r.Height
+		}
+		return (x2 - x1) * (y2 - y1)
+	}
+	area := 0
+	for i := range n.children {
+		area += n.children[i].Area(r, value)
+	}
+	return area
+}
+
 func (n *Node) split() {
 	size := n.Size / 2
 	n.children = make([]Node, 4)
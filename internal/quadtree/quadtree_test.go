@@ -50,6 +50,30 @@ func TestTree(t *testing.T) {
 	}
 }
 
+func TestArea(t *testing.T) {
+	q := New(1920)
+	q.SetRegion(Region{0, 0, 100, 100}, 1)
+	q.SetRegion(Region{50, 50, 100, 100}, 2)
+
+	var tests = []struct {
+		r     Region
+		value int
+		out   int
+	}{
+		{Region{0, 0, 100, 100}, 1, 7500},
+		{Region{0, 0, 100, 100}, 2, 2500},
+		{Region{0, 0, 100, 100}, 0, 0},
+		{Region{100, 100, 100, 100}, 2, 2500},
+		{Region{100, 100, 100, 100}, 0, 7500},
+		{Region{500, 500, 10, 10}, 0, 100},
+	}
+	for _, tt := range tests {
+		if ret := q.Area(tt.r, tt.value); ret != tt.out {
+			t.Errorf("q.Area(%v, %d) = %d, want %d", tt.r, tt.value, ret, tt.out)
+		}
+	}
+}
+
 func BenchmarkConstruction(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		q := New(3840)
@@ -12,11 +12,13 @@ tripped me up:
 */
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"math"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -76,31 +78,6 @@ func should(err error) {
 	log.Println("Error:", err)
 }
 
-func snapcalc(n0, n1, e0, e1, snapdist int) int {
-	var s0, s1 int
-
-	if abs(e0-n0) <= snapdist {
-		s0 = e0 - n0
-	}
-
-	if abs(e1-n1) <= snapdist {
-		s1 = e1 - n1
-	}
-
-	if s0 != 0 && s1 != 0 {
-		if abs(s0) < abs(s1) {
-			return s0
-		}
-		return s1
-	} else if s0 != 0 {
-		return s0
-	} else if s1 != 0 {
-		return s1
-	}
-
-	return 0
-}
-
 func LogWindowEvent(win *Window, s interface{}) {
 	log.Printf("%d (%s): %s", win.Id, win.Name(), s)
 }
@@ -199,6 +176,10 @@ type drag struct {
 	start   Point
 	offset  Point
 	corner  corner
+	// tile previews the part of the screen the window will be tiled
+	// to when it is dropped.
+	tile     *Rectangle
+	tileGeom Geometry
 }
 
 type Layer int
@@ -249,15 +230,48 @@ type Window struct {
 	Layer             Layer
 	Layout            Layout
 	LayoutStack       []Layout
+	RedoStack         []Layout
 	Mapped            bool
 	BorderWidth       int
 	wm                *WM
 	curDrag           *drag
 	unfullscreenGeom  Geometry
 	unfullscreenLayer Layer
+	unmaximizeGeom    Geometry
 	frozen            bool
 	overlay           *Window
 	gcs               draw.GCs
+	allowedActions    []string
+	// untiledGeom is the geometry the window had before it was
+	// tiled by dragging it to a screen edge.
+	untiledGeom *Geometry
+	gridSnap    gridSnap
+	// floating windows are exempt from automatic tiling.
+	floating bool
+	// seq orders windows by the time they were first managed.
+	seq uint64
+	// swallowed is the terminal this window took the place of, and
+	// swallowedBy is the window that took the place of this
+	// terminal.
+	swallowed   *Window
+	swallowedBy *Window
+	skipTaskbar bool
+	skipPager   bool
+	modal       bool
+	// skipSearch and noFocus are set by rules, and keep the window
+	// out of the window search and from becoming the current window.
+	skipSearch bool
+	noFocus    bool
+	// ruleBorderWidth is the border width set by a rule, if any.
+	ruleBorderWidth *int
+	// ignoreUnmaps counts the UnmapNotify events caused by us
+	// unmapping the window, which mustn't withdraw it.
+	ignoreUnmaps int
+	// ignoreRootUnmaps counts the root's copies of the UnmapNotify
+	// events caused by us iconifying the window.
+	ignoreRootUnmaps int
+	// focusGrabs are the grabs made for click to focus.
+	focusGrabs []buttonGrab
 }
 
 func (win *Window) GCs() draw.GCs {
@@ -351,6 +365,9 @@ func (win *Window) MoveStep(xu *xgbutil.XUtil, rootX, rootY, eventX, eventY int)
 	if win.frozen {
 		return
 	}
+	if win.untiledGeom != nil {
+		win.untile()
+	}
 	dx := rootX - win.curDrag.offset.X
 	dy := rootY - win.curDrag.offset.Y
 
@@ -358,22 +375,26 @@ func (win *Window) MoveStep(xu *xgbutil.XUtil, rootX, rootY, eventX, eventY int)
 	win.Layout.X = win.curDrag.start.X + dx
 	win.Layout.Y = win.curDrag.start.Y + dy
 
-	screen := win.Screen()
-	screen = screen.subtractGap(win.wm.Config.Gap)
-
-	win.Layout.X += snapcalc(win.Layout.X, win.Layout.X+win.Layout.Width+win.BorderWidth*2,
-		screen.X, screen.X+screen.Width, win.wm.Config.Snapdist)
-	win.Layout.Y += snapcalc(win.Layout.Y, win.Layout.Y+win.Layout.Height+win.BorderWidth*2,
-		screen.Y, screen.Y+screen.Height, win.wm.Config.Snapdist)
+	win.snapMove(0, 0)
 	win.move()
+	win.updateEdgeTile(Point{rootX, rootY})
 }
 
 func (win *Window) MoveEnd(xu *xgbutil.XUtil, rootX, rootY, eventX, eventY int) {
+	if d := win.curDrag; d.tile != nil {
+		d.tile.Destroy()
+		// MoveBegin already pushed the layout from before the drag.
+		untiled := Geometry{d.start.X, d.start.Y, win.Layout.Width, win.Layout.Height}
+		win.Layout.Geometry = win.innerGeometry(d.tileGeom)
+		win.moveAndResize()
+		win.untiledGeom = &untiled
+	}
 	win.curDrag = nil
 }
 
 func (win *Window) ResizeBegin(xu *xgbutil.XUtil, rootX, rootY, eventX, eventY int) (bool, xproto.Cursor) {
 	win.PushLayout()
+	win.untiledGeom = nil
 
 	if eventX < 0 {
 		eventX = 0
@@ -429,8 +450,8 @@ func (win *Window) ResizeStep(xu *xgbutil.XUtil, rootX, rootY, eventX, eventY in
 		return
 	}
 
-	rootX -= win.wm.Config.BorderWidth
-	rootY -= win.wm.Config.BorderWidth
+	rootX -= win.BorderWidth
+	rootY -= win.BorderWidth
 	// FIXME do not query normal hints on each step, instead cache it
 	// and listen to changes
 	var (
@@ -533,6 +554,7 @@ func (win *Window) ResizeStep(xu *xgbutil.XUtil, rootX, rootY, eventX, eventY in
 		win.Layout.X += dx
 	}
 
+	win.snapResize(win.curDrag.corner)
 	win.moveAndResize()
 	win.WriteToOverlay(fmt.Sprintf("%d × %d", win.Layout.Width, win.Layout.Height))
 }
@@ -562,33 +584,70 @@ func (win *Window) Resize(w, h int) {
 
 func (win *Window) Freeze() {
 	win.frozen = true
+	win.updateAllowedActions()
 }
 
 func (win *Window) Unfreeze() {
 	win.frozen = false
+	win.updateAllowedActions()
 }
 
 func (win *Window) ToggleFreeze() {
 	win.frozen = !win.frozen
+	win.updateAllowedActions()
 }
 
-func (win *Window) PushLayout() {
-	if len(win.LayoutStack) > 0 && win.LayoutStack[len(win.LayoutStack)-1] == win.Layout {
-		return
+// pushLayout pushes l onto stack, dropping the oldest layout if the
+// stack grows too large.
+func pushLayout(stack []Layout, l Layout) []Layout {
+	if len(stack) > 0 && stack[len(stack)-1] == l {
+		return stack
 	}
-	win.LayoutStack = append(win.LayoutStack, win.Layout)
-	if len(win.LayoutStack) > 10 {
-		copy(win.LayoutStack, win.LayoutStack[1:])
-		win.LayoutStack = win.LayoutStack[:len(win.LayoutStack)-1]
+	stack = append(stack, l)
+	if len(stack) > 10 {
+		copy(stack, stack[1:])
+		stack = stack[:len(stack)-1]
 	}
+	return stack
+}
+
+// PushLayout saves the current layout so that it can be restored with
+// PopLayout. It clears the redo stack and records the window in the
+// current transaction, if any.
+func (win *Window) PushLayout() {
+	win.wm.record(win)
+	win.RedoStack = nil
+	win.LayoutStack = pushLayout(win.LayoutStack, win.Layout)
 }
 
 func (win *Window) PopLayout() {
+	if win.popLayout() {
+		win.CenterPointer()
+	}
+}
+
+// popLayout restores the most recently pushed layout, saving the
+// current one on the redo stack. It reports whether there was a
+// layout to restore.
+func (win *Window) popLayout() bool {
 	if len(win.LayoutStack) == 0 {
-		return
+		return false
 	}
 	l := win.LayoutStack[len(win.LayoutStack)-1]
 	win.LayoutStack = win.LayoutStack[:len(win.LayoutStack)-1]
+	win.RedoStack = pushLayout(win.RedoStack, win.Layout)
+	win.ApplyLayout(l)
+	return true
+}
+
+// RedoLayout reapplies the layout most recently undone by PopLayout.
+func (win *Window) RedoLayout() {
+	if len(win.RedoStack) == 0 {
+		return
+	}
+	l := win.RedoStack[len(win.RedoStack)-1]
+	win.RedoStack = win.RedoStack[:len(win.RedoStack)-1]
+	win.LayoutStack = pushLayout(win.LayoutStack, win.Layout)
 	win.ApplyLayout(l)
 	win.CenterPointer()
 }
@@ -600,7 +659,7 @@ func (win *Window) ApplyLayout(l Layout) {
 }
 
 func (win *Window) Fullscreen() {
-	if win.Layout.State == Fullscreen {
+	if (win.Layout.State & Fullscreen) > 0 {
 		return
 	}
 
@@ -611,7 +670,7 @@ func (win *Window) Fullscreen() {
 	win.SetBorderWidth(0)
 	win.Layout.Geometry = sc
 	win.moveAndResizeNoReset()
-	win.Layout.State = Fullscreen
+	win.Layout.State |= Fullscreen
 	win.Freeze()
 	win.unfullscreenLayer = win.Layer
 	win.SetLayer(LayerAbove)
@@ -620,22 +679,21 @@ func (win *Window) Fullscreen() {
 }
 
 func (win *Window) Unfullscreen() {
-
-	if win.Layout.State != Fullscreen {
+	if (win.Layout.State & Fullscreen) == 0 {
 		return
 	}
 
 	win.Layout.Geometry = win.unfullscreenGeom
-	win.SetBorderWidth(win.wm.Config.BorderWidth)
+	win.SetBorderWidth(win.normalBorderWidth())
 	win.moveAndResizeNoReset()
-	win.Layout.State = 0
+	win.Layout.State &= ^Fullscreen
 	win.Unfreeze()
 	win.SetLayer(win.unfullscreenLayer)
 	win.updateWmState()
 }
 
 func (win *Window) ToggleFullscreen() {
-	if win.Layout.State == Fullscreen {
+	if (win.Layout.State & Fullscreen) > 0 {
 		win.Unfullscreen()
 	} else {
 		win.Fullscreen()
@@ -664,6 +722,20 @@ func (wm *WM) MappedWindows() []*Window {
 	return wm.GetWindows(icccm.StateNormal)
 }
 
+// clientWindows returns all managed client windows that are mapped or
+// iconified, in the order they were first managed in.
+func (wm *WM) clientWindows() []*Window {
+	var wins []*Window
+	for _, win := range wm.Windows {
+		if win == wm.Root || (win.State != icccm.StateNormal && win.State != icccm.StateIconic) {
+			continue
+		}
+		wins = append(wins, win)
+	}
+	sort.Slice(wins, func(i, j int) bool { return wins[i].seq < wins[j].seq })
+	return wins
+}
+
 func (wm *WM) VisibleWindows() []*Window {
 	wins := wm.MappedWindows()
 	q := quadtree.New(3840) // XXX get screen size
@@ -750,6 +822,130 @@ func (win *Window) collisions(with []*Window) (left, top, right, bottom int) {
 	return left, top, right, bottom
 }
 
+type Direction int
+
+const (
+	DirLeft Direction = iota
+	DirRight
+	DirUp
+	DirDown
+)
+
+// overlap returns by how much the intervals [a0, a1] and [b0, b1]
+// overlap. It is negative if they don't overlap.
+func overlap(a0, a1, b0, b1 int) int {
+	lo, hi := a0, a1
+	if b0 > lo {
+		lo = b0
+	}
+	if b1 < hi {
+		hi = b1
+	}
+	return hi - lo
+}
+
+// Neighbour returns the nearest visible window in direction dir, or
+// nil. Windows that overlap with win along the other axis are
+// preferred, then the distance between the facing edges and finally
+// the offset between the windows' centers decide.
+func (win *Window) Neighbour(dir Direction) *Window {
+	p1, _, p3, _ := win.Corners()
+	c := win.Center()
+
+	var (
+		best        *Window
+		bestOverlap bool
+		bestDist    int
+		bestOff     int
+	)
+	for _, owin := range win.wm.VisibleWindows() {
+		if win.Id == owin.Id {
+			continue
+		}
+		if owin.HasType("_NET_WM_WINDOW_TYPE_DOCK") || owin.HasType("_NET_WM_WINDOW_TYPE_DESKTOP") {
+			continue
+		}
+		op1, _, op3, _ := owin.Corners()
+		oc := owin.Center()
+
+		var dist, ov, off int
+		switch dir {
+		case DirLeft:
+			if oc.X >= c.X {
+				continue
+			}
+			dist = p1.X - op3.X
+			ov = overlap(p1.Y, p3.Y, op1.Y, op3.Y)
+			off = abs(oc.Y - c.Y)
+		case DirRight:
+			if oc.X <= c.X {
+				continue
+			}
+			dist = op1.X - p3.X
+			ov = overlap(p1.Y, p3.Y, op1.Y, op3.Y)
+			off = abs(oc.Y - c.Y)
+		case DirUp:
+			if oc.Y >= c.Y {
+				continue
+			}
+			dist = p1.Y - op3.Y
+			ov = overlap(p1.X, p3.X, op1.X, op3.X)
+			off = abs(oc.X - c.X)
+		case DirDown:
+			if oc.Y <= c.Y {
+				continue
+			}
+			dist = op1.Y - p3.Y
+			ov = overlap(p1.X, p3.X, op1.X, op3.X)
+			off = abs(oc.X - c.X)
+		}
+		if dist < 0 {
+			// the windows overlap
+			dist = 0
+		}
+
+		hasOverlap := ov > 0
+		switch {
+		case best == nil,
+			hasOverlap && !bestOverlap,
+			hasOverlap == bestOverlap && dist < bestDist,
+			hasOverlap == bestOverlap && dist == bestDist && off < bestOff:
+			best, bestOverlap, bestDist, bestOff = owin, hasOverlap, dist, off
+		}
+	}
+	return best
+}
+
+// FocusNeighbour activates the nearest visible window in direction
+// dir.
+func (win *Window) FocusNeighbour(dir Direction) {
+	if n := win.Neighbour(dir); n != nil {
+		n.Activate()
+	}
+}
+
+// SwapNeighbour exchanges the layouts of win and the nearest visible
+// window in direction dir.
+func (win *Window) SwapNeighbour(dir Direction) {
+	n := win.Neighbour(dir)
+	if n == nil {
+		return
+	}
+	if ((win.Layout.State | n.Layout.State) & Fullscreen) > 0 {
+		LogWindowEvent(win, "Not swapping fullscreen window")
+		return
+	}
+	win.wm.beginTransaction()
+	win.PushLayout()
+	n.PushLayout()
+	win.wm.endTransaction(nil)
+	l, nl := win.Layout, n.Layout
+	win.unmaximizeGeom, n.unmaximizeGeom = n.unmaximizeGeom, win.unmaximizeGeom
+	win.ApplyLayout(nl)
+	n.ApplyLayout(l)
+	win.CenterPointer()
+}
+
 type Rectangle struct {
 	bw  uint16
 	bc  int
@@ -1011,22 +1207,75 @@ func (win *Window) PushRight() {
 	win.moveNoReset()
 }
 
+// Maximize maximizes the window along the axes in state, remembering
+// the geometry along those axes so that Unmaximize can restore it.
 func (win *Window) Maximize(state MaximizedState) {
-	// TODO what about min/max size and increments?
+	state &= MaximizedFull &^ win.Layout.State
+	if state == 0 {
+		return
+	}
 
 	win.PushLayout()
+	if (state & MaximizedH) > 0 {
+		win.unmaximizeGeom.X = win.Layout.X
+		win.unmaximizeGeom.Width = win.Layout.Width
+	}
+	if (state & MaximizedV) > 0 {
+		win.unmaximizeGeom.Y = win.Layout.Y
+		win.unmaximizeGeom.Height = win.Layout.Height
+	}
+	win.Layout.State |= state
+	win.applyMaximize()
+}
 
-	sc := win.Screen().subtractGap(win.wm.Config.Gap)
+// Unmaximize restores the window's geometry along the maximized axes
+// in state.
+func (win *Window) Unmaximize(state MaximizedState) {
+	state &= win.Layout.State & MaximizedFull
+	if state == 0 {
+		return
+	}
+
+	win.PushLayout()
 	if (state & MaximizedH) > 0 {
-		win.Layout.X = sc.X
-		win.Layout.Width = sc.Width - 2*win.wm.Config.BorderWidth
+		win.Layout.X = win.unmaximizeGeom.X
+		win.Layout.Width = win.unmaximizeGeom.Width
 	}
 	if (state & MaximizedV) > 0 {
+		win.Layout.Y = win.unmaximizeGeom.Y
+		win.Layout.Height = win.unmaximizeGeom.Height
+	}
+	win.Layout.State &= ^state
+	win.moveAndResizeNoReset()
+	win.updateWmState()
+}
+
+// ToggleMaximize unmaximizes the window if it is maximized along all
+// axes in state, and maximizes it otherwise.
+func (win *Window) ToggleMaximize(state MaximizedState) {
+	if (win.Layout.State & state) == state {
+		win.Unmaximize(state)
+	} else {
+		win.Maximize(state)
+	}
+}
+
+// applyMaximize sizes the window to fill its screen along the axes it
+// is maximized on. It is used both when maximizing and when the
+// screens or gaps change.
+func (win *Window) applyMaximize() {
+	// TODO what about min/max size and increments?
+
+	sc := win.Screen().subtractGap(win.wm.Config.Gap)
+	if (win.Layout.State & MaximizedH) > 0 {
+		win.Layout.X = sc.X
+		win.Layout.Width = sc.Width - 2*win.BorderWidth
+	}
+	if (win.Layout.State & MaximizedV) > 0 {
 		win.Layout.Y = sc.Y
-		win.Layout.Height = sc.Height - 2*win.wm.Config.BorderWidth
+		win.Layout.Height = sc.Height - 2*win.BorderWidth
 	}
 	win.moveAndResizeNoReset()
-	win.Layout.State |= state
 	win.updateWmState()
 }
 
@@ -1036,7 +1285,7 @@ func (win *Window) ContainsPointer() bool {
 
 func (win *Window) CenterPointer() {
 	xproto.WarpPointer(win.wm.X.Conn(), xproto.WindowNone, win.Id, 0, 0, 0, 0,
-		int16(win.Layout.Width/2-win.wm.Config.BorderWidth), int16(win.Layout.Height/2-win.wm.Config.BorderWidth))
+		int16(win.Layout.Width/2-win.BorderWidth), int16(win.Layout.Height/2-win.BorderWidth))
 }
 
 // move moves the window based on its current Geom. It also resets the
@@ -1074,61 +1323,398 @@ func (win *Window) moveAndResizeNoReset() {
 	win.Window.MoveResize(win.Layout.X, win.Layout.Y, win.Layout.Width, win.Layout.Height)
 }
 
+func (win *Window) PropertyNotify(xu *xgbutil.XUtil, ev xevent.PropertyNotifyEvent) {
+	name, err := xprop.AtomName(xu, ev.Atom)
+	if err != nil {
+		return
+	}
+	switch name {
+	case "WM_NORMAL_HINTS", "_NET_WM_WINDOW_TYPE":
+		win.updateAllowedActions()
+	}
+}
+
 func (win *Window) EnterNotify(xu *xgbutil.XUtil, ev xevent.EnterNotifyEvent) {
 	LogWindowEvent(win, "Enter")
+	switch win.wm.Config.Focus {
+	case config.FocusClick:
+		return
+	case config.FocusStrict:
+		if win == win.wm.Root {
+			win.wm.Unfocus()
+			return
+		}
+	}
+	if win == win.wm.Root {
+		return
+	}
+	win.markActive()
+	win.wm.autoRaise(win)
+}
+
+// lockMasks are the combinations of lock modifiers that must not
+// affect button grabs.
+var lockMasks = []uint16{0, xproto.ModMaskLock, xproto.ModMask2, xproto.ModMaskLock | xproto.ModMask2}
+
+// maxButton is the highest button that clicking a window with focuses
+// it when using click to focus.
+const maxButton = 9
+
+// buttonGrab is a passive grab of a button with modifiers.
+type buttonGrab struct {
+	button xproto.Button
+	mods   uint16
+}
+
+// mouseBindGrabs returns the grabs made for the mouse bindings.
+func (wm *WM) mouseBindGrabs() map[buttonGrab]bool {
+	grabs := make(map[buttonGrab]bool)
+	for _, ms := range wm.Config.MouseBinds {
+		mods, button, err := mousebind.ParseString(wm.X, ms.ToXGB())
+		if err != nil {
+			continue
+		}
+		for _, lock := range lockMasks {
+			grabs[buttonGrab{button, mods | lock}] = true
+		}
+	}
+	return grabs
+}
+
+// grabFocusClick grabs the unmodified buttons on the window, so that
+// clicking it focuses it when using click to focus. Buttons that mouse
+// bindings use without modifiers are left to the bindings.
+func (win *Window) grabFocusClick() {
+	if len(win.focusGrabs) > 0 {
+		return
+	}
+	bound := win.wm.mouseBindGrabs()
+	for button := xproto.Button(1); button <= maxButton; button++ {
+		for _, mods := range lockMasks {
+			g := buttonGrab{button, mods}
+			if bound[g] {
+				continue
+			}
+			xproto.GrabButton(win.wm.X.Conn(), false, win.Id, xproto.EventMaskButtonPress,
+				xproto.GrabModeSync, xproto.GrabModeAsync, xproto.WindowNone, xproto.CursorNone,
+				byte(button), mods)
+			win.focusGrabs = append(win.focusGrabs, g)
+		}
+	}
+}
+
+// ungrabFocusClick releases the grabs made by grabFocusClick, and no
+// others.
+func (win *Window) ungrabFocusClick() {
+	for _, g := range win.focusGrabs {
+		xproto.UngrabButton(win.wm.X.Conn(), byte(g.button), win.Id, g.mods)
+	}
+	win.focusGrabs = nil
+}
+
+func (win *Window) ButtonPress(xu *xgbutil.XUtil, ev xevent.ButtonPressEvent) {
+	// Only presses through the focus click grabs concern us. Their
+	// grab is synchronous, the client only gets the click once we
+	// replay it. The low byte of the state holds the modifiers.
+	g := buttonGrab{ev.Detail, ev.State & 0xff}
+	grabbed := false
+	for _, fg := range win.focusGrabs {
+		if fg == g {
+			grabbed = true
+			break
+		}
+	}
+	if !grabbed {
+		return
+	}
+	defer xproto.AllowEvents(win.wm.X.Conn(), xproto.AllowReplayPointer, ev.Time)
 	win.markActive()
+	if win.wm.Config.ClickRaise {
+		win.Raise()
+	}
 }
 
 func (win *Window) Activate() {
 	// FIXME what do we do if the window is hidden behind a different
 	// layer?
+	if win.wm.showingDesktop {
+		win.wm.UnshowDesktop()
+	}
+	win.Deiconify()
 	win.Raise()
+	win.markActive()
 	win.CenterPointer()
 }
 
 func (win *Window) markActive() {
+	// Modal dialogs keep the focus over the windows they are modal
+	// for.
+	seen := map[*Window]bool{win: true}
+	for modal := win.modalChild(); modal != nil && !seen[modal]; modal = win.modalChild() {
+		seen[modal] = true
+		win = modal
+	}
 	if win == win.wm.CurWindow {
 		return
 	}
-	// TODO how do we close clients that don't accept focus?
-	if !win.Focusable() {
-		LogWindowEvent(win, "not focusable, skipping")
+	if win.noFocus || win.HasType("_NET_WM_WINDOW_TYPE_DOCK") || win.HasType("_NET_WM_WINDOW_TYPE_DESKTOP") {
 		return
 	}
+	// Windows that don't accept input still become the current
+	// window, so that they can be moved or closed with key bindings;
+	// Focus takes care of not giving them the input focus.
 	win.SetBorderColor(win.wm.Color(win.wm.Config.Colors["activeborder"]))
 	win.Focus()
+	clickToFocus := win.wm.Config.Focus == config.FocusClick
+	if clickToFocus {
+		win.ungrabFocusClick()
+	}
 	if curwin := win.wm.CurWindow; curwin != nil {
 		curwin.SetBorderColor(win.wm.Color(win.wm.Config.Colors["inactiveborder"]))
+		if clickToFocus {
+			curwin.grabFocusClick()
+		}
 	}
 	win.wm.CurWindow = win
+	win.wm.forgetFocus(win)
+	win.wm.focusHistory = append(win.wm.focusHistory, win)
+	win.wm.arrange(win.Screen())
 }
 
-func (win *Window) Focus() {
-	win.Window.Focus()
-	should(ewmh.ActiveWindowSet(win.wm.X, win.Id))
+// forgetFocus removes win from the focus history.
+func (wm *WM) forgetFocus(win *Window) {
+	for i, w := range wm.focusHistory {
+		if w == win {
+			wm.focusHistory = append(wm.focusHistory[:i], wm.focusHistory[i+1:]...)
+			return
+		}
+	}
 }
 
-func (win *Window) Focusable() bool {
+// focusFallback focuses the most recently focused window that is
+// still visible, after the current window went away. If there is no
+// such window, nothing will be focused.
+func (wm *WM) focusFallback() {
+	for i := len(wm.focusHistory) - 1; i >= 0; i-- {
+		win := wm.focusHistory[i]
+		if win == wm.CurWindow || !win.Mapped || win.State != icccm.StateNormal {
+			continue
+		}
+		LogWindowEvent(win, "Falling back to focusing")
+		win.markActive()
+		return
+	}
+	wm.Unfocus()
+}
+
+// Unfocus removes the focus from the current window, without focusing
+// a different one.
+func (wm *WM) Unfocus() {
+	if curwin := wm.CurWindow; curwin != nil {
+		curwin.SetBorderColor(wm.Color(wm.Config.Colors["inactiveborder"]))
+		if wm.Config.Focus == config.FocusClick {
+			curwin.grabFocusClick()
+		}
+	}
+	wm.CurWindow = nil
+	xproto.SetInputFocus(wm.X.Conn(), xproto.InputFocusPointerRoot, wm.Root.Id, wm.X.TimeGet())
+	should(ewmh.ActiveWindowSet(wm.X, xproto.WindowNone))
+}
+
+// autoRaise raises win after the configured delay, unless it lost the
+// focus in the meantime.
+func (wm *WM) autoRaise(win *Window) {
+	if wm.Config.AutoRaise <= 0 {
+		return
+	}
+	if wm.autoRaiseTimer != nil {
+		wm.autoRaiseTimer.Stop()
+	}
+	wm.autoRaiseTimer = time.AfterFunc(time.Duration(wm.Config.AutoRaise)*time.Millisecond, func() {
+		wm.chFn <- func() {
+			if wm.CurWindow == win {
+				win.Raise()
+			}
+		}
+	})
+}
+
+// InputModel is one of the four input focus models defined by the
+// ICCCM.
+type InputModel int
+
+const (
+	InputNone InputModel = iota
+	InputPassive
+	InputLocallyActive
+	InputGloballyActive
+)
+
+func (m InputModel) String() string {
+	switch m {
+	case InputNone:
+		return "No Input"
+	case InputPassive:
+		return "Passive"
+	case InputLocallyActive:
+		return "Locally Active"
+	case InputGloballyActive:
+		return "Globally Active"
+	default:
+		return fmt.Sprintf("InputModel(%d)", int(m))
+	}
+}
+
+// InputModel determines the window's input model from the input field
+// of its WM_HINTS and the presence of WM_TAKE_FOCUS in its
+// WM_PROTOCOLS.
+func (win *Window) InputModel() InputModel {
+	input := true
 	hints, err := icccm.WmHintsGet(win.wm.X, win.Id)
 	if err != nil {
 		LogWindowEvent(win, "Could not read hints")
-		return true
+	} else if (hints.Flags & icccm.HintInput) > 0 {
+		input = hints.Input == 1
+	}
+	takeFocus := win.SupportsProtocol("WM_TAKE_FOCUS")
+
+	switch {
+	case input && !takeFocus:
+		return InputPassive
+	case input && takeFocus:
+		return InputLocallyActive
+	case !input && takeFocus:
+		return InputGloballyActive
+	default:
+		return InputNone
+	}
+}
+
+// Focus gives the window the input focus, in the way its input model
+// demands.
+func (win *Window) Focus() {
+	switch model := win.InputModel(); model {
+	case InputNone:
+		// Move the focus away from the previously focused window,
+		// while still allowing our key bindings to work.
+		xproto.SetInputFocus(win.wm.X.Conn(), xproto.InputFocusPointerRoot, win.wm.Root.Id, win.wm.X.TimeGet())
+	case InputPassive:
+		// Not win.Window.Focus, which uses CurrentTime.
+		xproto.SetInputFocus(win.wm.X.Conn(), xproto.InputFocusPointerRoot, win.Id, win.wm.X.TimeGet())
+	case InputLocallyActive, InputGloballyActive:
+		// Clients that participate in WM_TAKE_FOCUS set the focus
+		// themselves, possibly to one of their subwindows.
+		if !win.SendMessage("WM_TAKE_FOCUS") {
+			LogWindowEvent(win, "Could not send WM_TAKE_FOCUS to "+model.String()+" window")
+		}
 	}
-	return (hints.Flags&icccm.HintInput) == 0 || hints.Input == 1 || win.SupportsProtocol("WM_TAKE_FOCUS")
+	should(ewmh.ActiveWindowSet(win.wm.X, win.Id))
+}
+
+func (win *Window) Focusable() bool {
+	return win.InputModel() != InputNone
 }
 
 func (win *Window) DestroyNotify(xu *xgbutil.XUtil, ev xevent.DestroyNotifyEvent) {
 	LogWindowEvent(win, "Destroying")
+	win.unswallow()
+	if term := win.swallowedBy; term != nil {
+		term.swallowed = nil
+	}
 	win.Detach()
 	win.overlay = nil
 	delete(win.wm.Windows, win.Id)
+	win.wm.forgetFocus(win)
+	if win == win.wm.CurWindow {
+		// Don't touch the destroyed window when focusing a different
+		// one.
+		win.wm.CurWindow = nil
+		win.wm.focusFallback()
+	}
+	win.wm.arrange(win.Screen())
+}
+
+// ignoreUnmap reports whether an UnmapNotify for the window that was
+// delivered through event mustn't withdraw the window.
+func (win *Window) ignoreUnmap(event xproto.Window) bool {
+	if event == win.Id {
+		if win.ignoreUnmaps > 0 {
+			win.ignoreUnmaps--
+			return true
+		}
+		return false
+	}
+	// Every unmap is delivered twice, once through the root's
+	// SubstructureNotify and once through the window's own
+	// StructureNotify, and xgbutil dispatches both to us by
+	// ev.Window. Only count the window's own copy, except for iconic
+	// windows: they are already unmapped, and clients withdraw them
+	// by sending a synthetic UnmapNotify to the root only.
+	if event != win.wm.Root.Id || win.State != icccm.StateIconic {
+		return true
+	}
+	if win.ignoreRootUnmaps > 0 {
+		// The root's copy of the unmap that iconified the window.
+		win.ignoreRootUnmaps--
+		return true
+	}
+	return false
 }
 
 func (win *Window) UnmapNotify(xu *xgbutil.XUtil, ev xevent.UnmapNotifyEvent) {
+	if win.ignoreUnmap(ev.Event) {
+		return
+	}
 	LogWindowEvent(win, "Unmapping")
+	win.unswallow()
+	win.escapeSwallow()
 	win.Mapped = false
 	win.State = icccm.StateWithdrawn
 	icccm.WmStateSet(win.wm.X, win.Id, &icccm.WmState{State: uint(win.State)})
+	if win == win.wm.CurWindow {
+		win.wm.focusFallback()
+	}
+	win.wm.arrange(win.Screen())
+}
+
+// Iconify unmaps the window and puts it in the iconic state.
+func (win *Window) Iconify() {
+	if win.State == icccm.StateIconic {
+		return
+	}
+	LogWindowEvent(win, "Iconifying")
+	win.ignoreUnmaps++
+	win.ignoreRootUnmaps++
+	win.Unmap()
+	win.Mapped = false
+	win.State = icccm.StateIconic
+	should(icccm.WmStateSet(win.wm.X, win.Id, &icccm.WmState{State: uint(win.State)}))
+	win.updateWmState()
+	if win == win.wm.CurWindow {
+		win.wm.focusFallback()
+	}
+	win.wm.arrange(win.Screen())
+}
+
+// Deiconify maps an iconified window and puts it back in the normal
+// state.
+func (win *Window) Deiconify() {
+	if win.State != icccm.StateIconic {
+		return
+	}
+	LogWindowEvent(win, "Deiconifying")
+	// Showing a swallowed terminal, for example by activating it,
+	// ends the swallowing.
+	win.escapeSwallow()
+	// The root's copy of an unmap that is still underway will be
+	// ignored for the window no longer being iconic.
+	win.ignoreRootUnmaps = 0
+	win.State = icccm.StateNormal
+	win.Map()
+	win.Mapped = true
+	should(icccm.WmStateSet(win.wm.X, win.Id, &icccm.WmState{State: uint(win.State)}))
+	win.updateWmState()
+	win.wm.arrange(win.Screen())
 }
 
 func (win *Window) ShowOverlay() {
@@ -1154,16 +1740,35 @@ func (win *Window) WriteToOverlay(s string) {
 	win.overlay.Resize(w, h)
 }
 
-func (win *Window) Init() {
-	// TODO do something if the state is iconified
-	LogWindowEvent(win, "Initializing")
-	win.SetBorderWidth(win.wm.Config.BorderWidth)
-	win.SetBorderColor(win.wm.Color(win.wm.Config.Colors["inactiveborder"]))
+// grabButtons grabs the configured mouse bindings on the window.
+func (win *Window) grabButtons() {
+	if ms, ok := win.wm.Config.MouseBinds["window_move"]; ok {
+		mousebind.Drag(win.wm.X, win.Id, win.Id, ms.ToXGB(), true,
+			win.MoveBegin, win.MoveStep, win.MoveEnd)
+	}
 
-	attr, err := xproto.GetGeometry(win.wm.X.Conn(), xproto.Drawable(win.Id)).Reply()
-	if err != nil {
-		should(err)
-	} else {
+	if ms, ok := win.wm.Config.MouseBinds["window_resize"]; ok {
+		mousebind.Drag(win.wm.X, win.Id, win.Id, ms.ToXGB(), true,
+			win.ResizeBegin, win.ResizeStep, win.ResizeEnd)
+	}
+
+	if ms, ok := win.wm.Config.MouseBinds["window_lower"]; ok {
+		fn := func(xu *xgbutil.XUtil, ev xevent.ButtonPressEvent) { win.Lower() }
+		should(mousebind.ButtonPressFun(fn).Connect(win.wm.X, win.Id, ms.ToXGB(), false, true))
+	}
+}
+
+func (win *Window) Init() {
+	// TODO do something if the state is iconified
+	LogWindowEvent(win, "Initializing")
+	win.updateAllowedActions()
+	win.SetBorderWidth(win.wm.Config.BorderWidth)
+	win.SetBorderColor(win.wm.Color(win.wm.Config.Colors["inactiveborder"]))
+
+	attr, err := xproto.GetGeometry(win.wm.X.Conn(), xproto.Drawable(win.Id)).Reply()
+	if err != nil {
+		should(err)
+	} else {
 		win.Layout.X = int(attr.X)
 		win.Layout.Y = int(attr.Y)
 		win.Layout.Width = int(attr.Width)
@@ -1181,20 +1786,11 @@ func (win *Window) Init() {
 			win.addState(state)
 		}
 	}
+	win.restoreState()
 
-	if ms, ok := win.wm.Config.MouseBinds["window_move"]; ok {
-		mousebind.Drag(win.wm.X, win.Id, win.Id, ms.ToXGB(), true,
-			win.MoveBegin, win.MoveStep, win.MoveEnd)
-	}
-
-	if ms, ok := win.wm.Config.MouseBinds["window_resize"]; ok {
-		mousebind.Drag(win.wm.X, win.Id, win.Id, ms.ToXGB(), true,
-			win.ResizeBegin, win.ResizeStep, win.ResizeEnd)
-	}
-
-	if ms, ok := win.wm.Config.MouseBinds["window_lower"]; ok {
-		fn := func(xu *xgbutil.XUtil, ev xevent.ButtonPressEvent) { win.Lower() }
-		should(mousebind.ButtonPressFun(fn).Connect(win.wm.X, win.Id, ms.ToXGB(), false, true))
+	win.grabButtons()
+	if win.wm.Config.Focus == config.FocusClick && win != win.wm.CurWindow {
+		win.grabFocusClick()
 	}
 
 	w, err := xwindow.Create(win.wm.X, win.wm.Root.Id)
@@ -1235,6 +1831,18 @@ func (win *Window) ClientMessage(xu *xgbutil.XUtil, ev xevent.ClientMessageEvent
 		win.handleState(prop2, data)
 	case "_NET_CLOSE_WINDOW":
 		win.Delete()
+	case "_NET_ACTIVE_WINDOW":
+		win.Activate()
+	case "WM_CHANGE_STATE":
+		if data[0] == icccm.StateIconic {
+			win.Iconify()
+		}
+	case "_NET_SHOWING_DESKTOP":
+		if data[0] != 0 {
+			win.wm.ShowDesktop()
+		} else {
+			win.wm.UnshowDesktop()
+		}
 	case "_NET_WM_MOVERESIZE":
 		// Notes:
 		// - currently we only support mouse-initiated actions
@@ -1293,7 +1901,10 @@ func (win *Window) SendMessage(name string) bool {
 		return false
 	}
 
-	cm, err := xevent.NewClientMessage(32, win.Id, protAtm, int(nAtm))
+	// ICCCM requires a valid timestamp, not CurrentTime. TimeGet is
+	// the time of the most recent event, or the server time fetched
+	// in Init.
+	cm, err := xevent.NewClientMessage(32, win.Id, protAtm, int(nAtm), int(win.wm.X.TimeGet()))
 	if err != nil {
 		LogWindowEvent(win, err)
 		return false
@@ -1334,7 +1945,25 @@ func (win *Window) SupportsProtocol(name string) bool {
 	return false
 }
 
+// stateActions maps _NET_WM_STATE atoms to the allowed actions that
+// are required for adding them.
+var stateActions = map[string]string{
+	"_NET_WM_STATE_FULLSCREEN":     "_NET_WM_ACTION_FULLSCREEN",
+	"_NET_WM_STATE_MAXIMIZED_HORZ": "_NET_WM_ACTION_MAXIMIZE_HORZ",
+	"_NET_WM_STATE_MAXIMIZED_VERT": "_NET_WM_ACTION_MAXIMIZE_VERT",
+	"_NET_WM_STATE_ABOVE":          "_NET_WM_ACTION_ABOVE",
+	"_NET_WM_STATE_BELOW":          "_NET_WM_ACTION_BELOW",
+}
+
 func (win *Window) handleState(prop string, data []uint32) {
+	// Removing a state is always allowed, so that windows cannot get
+	// stuck in a state after their allowed actions changed.
+	adding := data[0] == 1 || (data[0] == 2 && !win.hasState(prop))
+	if action, ok := stateActions[prop]; ok && adding && !win.Allows(action) {
+		LogWindowEvent(win, "Not allowed to add "+prop)
+		return
+	}
+
 	switch data[0] {
 	case 0:
 		win.removeState(prop)
@@ -1350,9 +1979,24 @@ func (win *Window) removeState(prop string) {
 	case "_NET_WM_STATE_FULLSCREEN":
 		win.Unfullscreen()
 	case "_NET_WM_STATE_MAXIMIZED_HORZ":
+		win.Unmaximize(MaximizedH)
 	case "_NET_WM_STATE_MAXIMIZED_VERT":
+		win.Unmaximize(MaximizedV)
 	case "_NET_WM_STATE_ABOVE", "_NET_WM_STATE_BELOW":
 		win.SetLayer(LayerNormal)
+	case "_NET_WM_STATE_SKIP_TASKBAR":
+		win.skipTaskbar = false
+		win.updateWmState()
+	case "_NET_WM_STATE_SKIP_PAGER":
+		win.skipPager = false
+		win.updateWmState()
+	case "_NET_WM_STATE_MODAL":
+		win.modal = false
+		win.updateWmState()
+	case "_NET_WM_STATE_HIDDEN":
+		// HIDDEN follows the iconic state. Clients iconify windows
+		// with WM_CHANGE_STATE, but may show them by removing HIDDEN.
+		win.Deiconify()
 	default:
 		LogWindowEvent(win, "Unknown _NET_WM_STATE: "+prop)
 	}
@@ -1370,6 +2014,19 @@ func (win *Window) addState(prop string) {
 		win.SetLayer(LayerAbove)
 	case "_NET_WM_STATE_BELOW":
 		win.SetLayer(LayerBelow)
+	case "_NET_WM_STATE_SKIP_TASKBAR":
+		win.skipTaskbar = true
+		win.updateWmState()
+	case "_NET_WM_STATE_SKIP_PAGER":
+		win.skipPager = true
+		win.updateWmState()
+	case "_NET_WM_STATE_MODAL":
+		win.modal = true
+		win.updateWmState()
+	case "_NET_WM_STATE_HIDDEN":
+		// Hiding a window is left to WM_CHANGE_STATE, as EWMH
+		// recommends. Windows keep HIDDEN across restarts, which
+		// mustn't iconify them again.
 	default:
 		LogWindowEvent(win, "Unknown _NET_WM_STATE: "+prop)
 	}
@@ -1380,7 +2037,9 @@ func (win *Window) toggleState(prop string) {
 	case "_NET_WM_STATE_FULLSCREEN":
 		win.ToggleFullscreen()
 	case "_NET_WM_STATE_MAXIMIZED_HORZ":
+		win.ToggleMaximize(MaximizedH)
 	case "_NET_WM_STATE_MAXIMIZED_VERT":
+		win.ToggleMaximize(MaximizedV)
 	case "_NET_WM_STATE_ABOVE":
 		if win.Layer == LayerAbove {
 			win.SetLayer(LayerNormal)
@@ -1393,6 +2052,19 @@ func (win *Window) toggleState(prop string) {
 		} else {
 			win.SetLayer(LayerBelow)
 		}
+	case "_NET_WM_STATE_SKIP_TASKBAR":
+		win.skipTaskbar = !win.skipTaskbar
+		win.updateWmState()
+	case "_NET_WM_STATE_SKIP_PAGER":
+		win.skipPager = !win.skipPager
+		win.updateWmState()
+	case "_NET_WM_STATE_MODAL":
+		win.modal = !win.modal
+		win.updateWmState()
+	case "_NET_WM_STATE_HIDDEN":
+		// Like removing HIDDEN, only showing a hidden window is
+		// supported.
+		win.Deiconify()
 	default:
 		LogWindowEvent(win, "Unknown _NET_WM_STATE: "+prop)
 	}
@@ -1440,6 +2112,69 @@ func (win *Window) Attributes() *xproto.GetWindowAttributesReply {
 	return attr
 }
 
+// Types returns the window's _NET_WM_WINDOW_TYPE.
+func (win *Window) Types() []string {
+	types, err := ewmh.WmWindowTypeGet(win.wm.X, win.Id)
+	if err != nil {
+		return nil
+	}
+	return types
+}
+
+func (win *Window) HasType(typ string) bool {
+	for _, t := range win.Types() {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// TransientFor returns the window that win is transient for, or
+// xproto.WindowNone.
+func (win *Window) TransientFor() xproto.Window {
+	w, err := icccm.WmTransientForGet(win.wm.X, win.Id)
+	if err != nil {
+		return xproto.WindowNone
+	}
+	return w
+}
+
+// Group returns the window group win belongs to, or
+// xproto.WindowNone.
+func (win *Window) Group() xproto.Window {
+	hints, err := icccm.WmHintsGet(win.wm.X, win.Id)
+	if err != nil || (hints.Flags&icccm.HintWindowGroup) == 0 {
+		return xproto.WindowNone
+	}
+	return hints.WindowGroup
+}
+
+// modalChild returns a mapped modal dialog that is transient for win,
+// or for win's whole group, or nil.
+func (win *Window) modalChild() *Window {
+	var group xproto.Window
+	groupKnown := false
+	for _, ow := range win.wm.Windows {
+		if ow == win || !ow.modal || !ow.Mapped {
+			continue
+		}
+		switch parent := ow.TransientFor(); parent {
+		case win.Id:
+			return ow
+		case xproto.WindowNone, win.wm.Root.Id:
+			if !groupKnown {
+				group = win.Group()
+				groupKnown = true
+			}
+			if group != xproto.WindowNone && ow.Group() == group {
+				return ow
+			}
+		}
+	}
+	return nil
+}
+
 func (win *Window) Class() (name string, class string) {
 	repl, err := xprop.GetProperty(win.wm.X, win.Id, "WM_CLASS")
 	if err != nil {
@@ -1466,7 +2201,94 @@ func (win *Window) Screen() Geometry {
 	return screenForPoint(screens, win.Center())
 }
 
-func (win *Window) updateWmState() {
+// Allows reports whether the window currently allows the
+// _NET_WM_ACTION action.
+func (win *Window) Allows(action string) bool {
+	for _, a := range win.allowedActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// fixedSize reports, per axis, whether the window's size hints
+// prevent it from being resized.
+func (win *Window) fixedSize() (w, h bool) {
+	hints, err := icccm.WmNormalHintsGet(win.wm.X, win.Id)
+	if err != nil {
+		return false, false
+	}
+	if (hints.Flags&icccm.SizeHintPMinSize) == 0 || (hints.Flags&icccm.SizeHintPMaxSize) == 0 {
+		return false, false
+	}
+	return hints.MinWidth == hints.MaxWidth, hints.MinHeight == hints.MaxHeight
+}
+
+func (win *Window) updateAllowedActions() {
+	allowed := map[string]bool{
+		"_NET_WM_ACTION_MOVE":          true,
+		"_NET_WM_ACTION_RESIZE":        true,
+		"_NET_WM_ACTION_FULLSCREEN":    true,
+		"_NET_WM_ACTION_MAXIMIZE_HORZ": true,
+		"_NET_WM_ACTION_MAXIMIZE_VERT": true,
+		"_NET_WM_ACTION_ABOVE":         true,
+		"_NET_WM_ACTION_BELOW":         true,
+		"_NET_WM_ACTION_CLOSE":         true,
+	}
+	disallow := func(actions ...string) {
+		for _, action := range actions {
+			delete(allowed, action)
+		}
+	}
+
+	fixedW, fixedH := win.fixedSize()
+	if fixedW {
+		disallow("_NET_WM_ACTION_MAXIMIZE_HORZ")
+	}
+	if fixedH {
+		disallow("_NET_WM_ACTION_MAXIMIZE_VERT")
+	}
+	if fixedW && fixedH {
+		disallow("_NET_WM_ACTION_RESIZE")
+	}
+
+	if win.frozen {
+		disallow("_NET_WM_ACTION_MOVE", "_NET_WM_ACTION_RESIZE",
+			"_NET_WM_ACTION_MAXIMIZE_HORZ", "_NET_WM_ACTION_MAXIMIZE_VERT")
+	}
+
+	for _, typ := range win.Types() {
+		switch typ {
+		case "_NET_WM_WINDOW_TYPE_DESKTOP", "_NET_WM_WINDOW_TYPE_DOCK":
+			allowed = map[string]bool{}
+		case "_NET_WM_WINDOW_TYPE_SPLASH":
+			disallow("_NET_WM_ACTION_RESIZE", "_NET_WM_ACTION_FULLSCREEN",
+				"_NET_WM_ACTION_MAXIMIZE_HORZ", "_NET_WM_ACTION_MAXIMIZE_VERT")
+		case "_NET_WM_WINDOW_TYPE_UTILITY", "_NET_WM_WINDOW_TYPE_TOOLBAR", "_NET_WM_WINDOW_TYPE_MENU":
+			disallow("_NET_WM_ACTION_FULLSCREEN",
+				"_NET_WM_ACTION_MAXIMIZE_HORZ", "_NET_WM_ACTION_MAXIMIZE_VERT")
+		}
+	}
+
+	win.allowedActions = win.allowedActions[:0]
+	for action := range allowed {
+		win.allowedActions = append(win.allowedActions, action)
+	}
+	sort.Strings(win.allowedActions)
+	should(ewmh.WmAllowedActionsSet(win.wm.X, win.Id, win.allowedActions))
+}
+
+func (win *Window) hasState(prop string) bool {
+	for _, atom := range win.wmStates() {
+		if atom == prop {
+			return true
+		}
+	}
+	return false
+}
+
+func (win *Window) wmStates() []string {
 	var atoms []string
 	if (win.Layout.State & MaximizedH) > 0 {
 		atoms = append(atoms, "_NET_WM_STATE_MAXIMIZED_HORZ")
@@ -1474,7 +2296,7 @@ func (win *Window) updateWmState() {
 	if (win.Layout.State & MaximizedV) > 0 {
 		atoms = append(atoms, "_NET_WM_STATE_MAXIMIZED_VERT")
 	}
-	if win.Layout.State == Fullscreen {
+	if (win.Layout.State & Fullscreen) > 0 {
 		atoms = append(atoms, "_NET_WM_STATE_FULLSCREEN")
 	}
 	if win.Layer == LayerAbove {
@@ -1483,8 +2305,24 @@ func (win *Window) updateWmState() {
 	if win.Layer == LayerBelow {
 		atoms = append(atoms, "_NET_WM_STATE_BELOW")
 	}
+	if win.skipTaskbar {
+		atoms = append(atoms, "_NET_WM_STATE_SKIP_TASKBAR")
+	}
+	if win.skipPager {
+		atoms = append(atoms, "_NET_WM_STATE_SKIP_PAGER")
+	}
+	if win.modal {
+		atoms = append(atoms, "_NET_WM_STATE_MODAL")
+	}
+	if win.State == icccm.StateIconic {
+		atoms = append(atoms, "_NET_WM_STATE_HIDDEN")
+	}
 	// TODO other hints
-	ewmh.WmStateSet(win.wm.X, win.Id, atoms)
+	return atoms
+}
+
+func (win *Window) updateWmState() {
+	ewmh.WmStateSet(win.wm.X, win.Id, win.wmStates())
 }
 
 func (wm *WM) CycleScreens() {
@@ -1501,11 +2339,14 @@ func (wm *WM) CycleScreens() {
 		width += sc.Width
 	}
 
-	for _, win := range wm.Windows {
+	wm.beginTransaction()
+	for _, win := range wm.clientWindows() {
+		win.PushLayout()
 		win.Layout.X = (win.Layout.X + win.Screen().Width) % width
 		// FIXME this clears the maximized state
 		win.move()
 	}
+	wm.endTransaction(nil)
 }
 
 type WM struct {
@@ -1518,6 +2359,41 @@ type WM struct {
 	chFn      chan func()
 	font      xproto.Font
 	colors    map[string]int
+
+	// configPath is the file the configuration was loaded from.
+	configPath string
+	// commands are the functions that key bindings can invoke. They
+	// are the commands map, which functions that it refers to can't
+	// use directly without an initialization cycle.
+	commands map[string]func(*WM)
+	// monitors is the number of monitors the configuration was
+	// parsed for.
+	monitors int
+
+	showingDesktop bool
+	// desktopStack is the stacking order, bottom to top, of all mapped
+	// windows before we started showing the desktop.
+	desktopStack []*Window
+	desktopFocus *Window
+	// desktopTxn is the transaction that started showing the
+	// desktop.
+	desktopTxn *transaction
+
+	// focusHistory contains the windows that have been focused, in
+	// the order they were focused in, most recent last.
+	focusHistory   []*Window
+	autoRaiseTimer *time.Timer
+	cascadeOffset  int
+	// placing is the window that is currently being placed
+	// interactively.
+	placing *Window
+	tilings map[Geometry]*tiling
+	nextSeq uint64
+
+	// txn is the transaction currently being recorded, and
+	// transactions are the recorded ones, most recent last.
+	txn          *transaction
+	transactions []*transaction
 }
 
 func (wm *WM) MapRequest(xu *xgbutil.XUtil, ev xevent.MapRequestEvent) {
@@ -1527,6 +2403,16 @@ func (wm *WM) MapRequest(xu *xgbutil.XUtil, ev xevent.MapRequestEvent) {
 		LogWindowEvent(win, "Not mapping already mapped window")
 		return
 	}
+	if win == wm.placing {
+		LogWindowEvent(win, "Not mapping window that is being placed")
+		return
+	}
+	// Mapping an iconic window deiconifies it, which for a swallowed
+	// terminal ends the swallowing.
+	if win.State == icccm.StateIconic {
+		win.Deiconify()
+		return
+	}
 	// TODO what's the point of the initial state? will an iconified window be mapped?
 
 	hints, err := icccm.WmHintsGet(xu, win.Id)
@@ -1540,31 +2426,49 @@ func (wm *WM) MapRequest(xu *xgbutil.XUtil, ev xevent.MapRequestEvent) {
 	//
 	// Yes, we call Init when the WM first starts
 	win.Init()
-
-	normalHints, err := icccm.WmNormalHintsGet(win.wm.X, win.Id)
-	if err != nil || (normalHints.Flags&(icccm.SizeHintPPosition|icccm.SizeHintUSPosition) == 0) {
-		if win.Layout.State == 0 && win.Layout.State != Fullscreen {
-			ptr := win.wm.PointerPos()
-			win.Layout.X = ptr.X - win.Layout.Width/2
-			win.Layout.Y = ptr.Y - win.Layout.Height/2
+	placed := win.applyRules()
+
+	if term := wm.swallowingTerminal(win); term != nil {
+		win.swallow(term)
+	} else if !placed {
+		normalHints, err := icccm.WmNormalHintsGet(win.wm.X, win.Id)
+		if err != nil || (normalHints.Flags&(icccm.SizeHintPPosition|icccm.SizeHintUSPosition) == 0) {
+			if win.Layout.State == 0 {
+				win.place()
+				if win.manualPlacement() && win.PlaceInteractively(func() { wm.finishMapRequest(win, hints) }) {
+					return
+				}
+			}
 		}
 	}
+	wm.finishMapRequest(win, hints)
+}
 
-	win.moveNoReset()
+// finishMapRequest maps a window once its position has been decided.
+func (wm *WM) finishMapRequest(win *Window, hints *icccm.Hints) {
+	if (hints.Flags & icccm.HintState) == 0 {
+		hints.InitialState = icccm.StateNormal
+	}
+	icccm.WmStateSet(wm.X, win.Id, &icccm.WmState{State: hints.InitialState, Icon: 0})
+	win.State = State(hints.InitialState)
+	win.Mapped = true
+
+	win.moveAndResizeNoReset()
+	// Arrange before mapping, so that the window appears in its
+	// final place.
+	wm.arrange(win.Screen())
 	win.Map()
 	win.Raise()
 	// TODO probably should
 	// a) store the border width in every client
 	// b) use that for all calculations involving the border width
 	win.CenterPointer()
-	if (hints.Flags & icccm.HintState) == 0 {
-		hints.InitialState = icccm.StateNormal
-	}
-	icccm.WmStateSet(wm.X, win.Id, &icccm.WmState{State: hints.InitialState, Icon: 0})
-	win.State = State(hints.InitialState)
 
 	win.SendStructureNotify()
-	win.Mapped = true
+	if wm.Config.Focus == config.FocusClick {
+		// There is no EnterNotify to focus new windows.
+		win.markActive()
+	}
 
 	// Notes to self:
 	// - x, y, w, h in WM_NORMAL_HINTS are obsolete
@@ -1624,7 +2528,8 @@ func (wm *WM) NewWindow(c xproto.Window) *Window {
 		return win
 	}
 
-	win := &Window{wm: wm, Window: xwindow.New(wm.X, c), gcs: make(draw.GCs)}
+	win := &Window{wm: wm, Window: xwindow.New(wm.X, c), gcs: make(draw.GCs), seq: wm.nextSeq}
+	wm.nextSeq++
 	LogWindowEvent(win, "Managing window")
 	wm.Windows[c] = win
 
@@ -1641,12 +2546,14 @@ func (wm *WM) NewWindow(c xproto.Window) *Window {
 	}
 
 	should(win.Listen(xproto.EventMaskEnterWindow,
-		xproto.EventMaskStructureNotify))
+		xproto.EventMaskStructureNotify, xproto.EventMaskPropertyChange))
 
 	xevent.UnmapNotifyFun(win.UnmapNotify).Connect(win.wm.X, win.Id)
 	xevent.DestroyNotifyFun(win.DestroyNotify).Connect(win.wm.X, win.Id)
 	xevent.EnterNotifyFun(win.EnterNotify).Connect(win.wm.X, win.Id)
 	xevent.ClientMessageFun(win.ClientMessage).Connect(win.wm.X, win.Id)
+	xevent.PropertyNotifyFun(win.PropertyNotify).Connect(win.wm.X, win.Id)
+	xevent.ButtonPressFun(win.ButtonPress).Connect(win.wm.X, win.Id)
 
 	return win
 }
@@ -1658,6 +2565,13 @@ func (wm *WM) QueryTree() []xproto.Window {
 }
 
 func (wm *WM) RelevantQueryTree() []xproto.Window {
+	return wm.relevantQueryTree(false)
+}
+
+// relevantQueryTree returns the children of the root window that
+// aren't override-redirect and are viewable or, if iconic is set,
+// unmapped and in the iconic state.
+func (wm *WM) relevantQueryTree(iconic bool) []xproto.Window {
 	tree := wm.QueryTree()
 	var wins []xproto.Window
 	for _, c := range tree {
@@ -1665,9 +2579,18 @@ func (wm *WM) RelevantQueryTree() []xproto.Window {
 		if err != nil {
 			continue
 		}
-		if attr.OverrideRedirect || attr.MapState != xproto.MapStateViewable {
+		if attr.OverrideRedirect {
 			continue
 		}
+		if attr.MapState != xproto.MapStateViewable {
+			if !iconic || attr.MapState != xproto.MapStateUnmapped {
+				continue
+			}
+			state, err := icccm.WmStateGet(wm.X, c)
+			if err != nil || state.State != icccm.StateIconic {
+				continue
+			}
+		}
 		wins = append(wins, c)
 
 	}
@@ -1714,6 +2637,15 @@ func (wm *WM) Screens() []Geometry {
 	return geoms
 }
 
+// monitors returns the number of monitors.
+func monitors(xu *xgbutil.XUtil) int {
+	heads, err := xinerama.PhysicalHeads(xu)
+	if err != nil || len(heads) == 0 {
+		return 1
+	}
+	return len(heads)
+}
+
 func (wm *WM) LoadCursors(mapping map[string]uint16) {
 	var err error
 	for name, cursor := range mapping {
@@ -1731,6 +2663,40 @@ func (wm *WM) PointerPos() Point {
 	return Point{int(ptr.RootX), int(ptr.RootY)}
 }
 
+// RootConfigureNotify is called when the root window changes size,
+// which happens when screens are added, removed or reconfigured.
+func (wm *WM) RootConfigureNotify(xu *xgbutil.XUtil, ev xevent.ConfigureNotifyEvent) {
+	if ev.Window != wm.Root.Id {
+		return
+	}
+	log.Println("Screens changed")
+	if n := monitors(wm.X); n != wm.monitors {
+		wm.monitors = n
+		if wm.Config.DependsOnMonitors() {
+			wm.Reload()
+		}
+	}
+	wm.ScreensChanged()
+}
+
+// ScreensChanged refits fullscreen and maximized windows after the
+// screens or the gaps changed.
+func (wm *WM) ScreensChanged() {
+	for _, win := range wm.Windows {
+		if !win.Mapped {
+			continue
+		}
+		switch {
+		case (win.Layout.State & Fullscreen) > 0:
+			win.Layout.Geometry = win.Screen()
+			win.moveAndResizeNoReset()
+		case (win.Layout.State & MaximizedFull) > 0:
+			win.applyMaximize()
+		}
+	}
+	wm.arrangeAll()
+}
+
 func (wm *WM) CurrentScreen() Geometry {
 	screens := wm.Screens()
 	return screenForPoint(screens, wm.PointerPos())
@@ -1742,8 +2708,76 @@ func (wm *WM) debug() {
 	log.Println("END DEBUG")
 }
 
+// ShowDesktop iconifies all normal windows, leaving desktop and dock
+// windows alone.
+func (wm *WM) ShowDesktop() {
+	if wm.showingDesktop {
+		return
+	}
+	log.Println("Showing desktop")
+	wm.showingDesktop = true
+	wm.desktopStack = wm.MappedWindows()
+	wm.desktopFocus = wm.CurWindow
+	wm.beginTransaction()
+	// Unfocus first, instead of falling back from one hidden window
+	// to the next.
+	wm.Unfocus()
+	for _, win := range wm.desktopStack {
+		if win.HasType("_NET_WM_WINDOW_TYPE_DESKTOP") || win.HasType("_NET_WM_WINDOW_TYPE_DOCK") {
+			continue
+		}
+		win.Iconify()
+	}
+	wm.desktopTxn = wm.endTransaction(wm.UnshowDesktop)
+	should(ewmh.ShowingDesktopSet(wm.X, true))
+}
+
+// UnshowDesktop restores the windows hidden by ShowDesktop, including
+// their stacking order and the focus.
+func (wm *WM) UnshowDesktop() {
+	if !wm.showingDesktop {
+		return
+	}
+	log.Println("No longer showing desktop")
+	wm.showingDesktop = false
+	// Whether we are undoing it or not, the transaction that started
+	// showing the desktop has nothing left to undo.
+	wm.forgetTransaction(wm.desktopTxn)
+	wm.desktopTxn = nil
+	var stack []*Window
+	for _, win := range wm.desktopStack {
+		if wm.Windows[win.Id] != win {
+			// the window has been destroyed in the meantime
+			continue
+		}
+		win.Deiconify()
+		stack = append(stack, win)
+	}
+	wm.Restack(stack)
+
+	if win := wm.desktopFocus; win != nil && wm.Windows[win.Id] == win && win.Mapped {
+		if win == wm.CurWindow {
+			win.Focus()
+		} else {
+			win.markActive()
+		}
+	}
+	wm.desktopStack = nil
+	wm.desktopFocus = nil
+	should(ewmh.ShowingDesktopSet(wm.X, false))
+}
+
+func (wm *WM) ToggleShowDesktop() {
+	if wm.showingDesktop {
+		wm.UnshowDesktop()
+	} else {
+		wm.ShowDesktop()
+	}
+}
+
 func (wm *WM) Restart() {
 	log.Println("Restarting gwm")
+	wm.saveState()
 	if err := syscall.Exec(os.Args[0], os.Args, os.Environ()); err != nil {
 		log.Println("exec failed:", err)
 	}
@@ -1760,15 +2794,35 @@ func (wm *WM) WarpPointerRel(dx, dy int) {
 	xproto.WarpPointer(wm.X.Conn(), xproto.WindowNone, xproto.WindowNone, 0, 0, 0, 0, int16(dx), int16(dy))
 }
 
+// IconicWindows returns all iconified windows, except for terminals
+// that have been swallowed.
+func (wm *WM) IconicWindows() []*Window {
+	var wins []*Window
+	for _, c := range wm.QueryTree() {
+		win, ok := wm.Windows[c]
+		if !ok || win.State != icccm.StateIconic || win.swallowedBy != nil {
+			continue
+		}
+		wins = append(wins, win)
+	}
+	return wins
+}
+
 func (wm *WM) windowSearchMenu() {
-	wins := wm.MappedWindows() // FIXME hidden windows
+	wins := append(wm.MappedWindows(), wm.IconicWindows()...)
 	var entries []menu.Entry
 	for _, win := range wins {
+		if win.skipTaskbar || win.skipPager || win.skipSearch {
+			continue
+		}
 		// ! currently focused
 		// & hidden
-		// XXX will need to fix this when we support hiding windows
 		// XXX will need to fix this when we support groups
-		entry := menu.Entry{Display: " " + win.Name(), Payload: win}
+		prefix := " "
+		if win.State == icccm.StateIconic {
+			prefix = "&"
+		}
+		entry := menu.Entry{Display: prefix + win.Name(), Payload: win}
 		entries = append(entries, entry)
 	}
 	filter := func(entries []menu.Entry, prompt string) []menu.Entry {
@@ -1787,7 +2841,7 @@ func (wm *WM) windowSearchMenu() {
 				_, class := win.Class()
 				if strings.Contains(strings.ToLower(class), prompt) {
 					tier = 3
-					entry.Display = " " + class + ":" + entry.Display[1:]
+					entry.Display = entry.Display[:1] + class + ":" + entry.Display[1:]
 				}
 			}
 
@@ -1802,7 +2856,9 @@ func (wm *WM) windowSearchMenu() {
 				}
 			}
 
-			// TODO rank one up if hidden
+			if win.State == icccm.StateIconic && tier > 0 {
+				tier--
+			}
 			outTiers[tier] = append(outTiers[tier], entry)
 		}
 
@@ -1848,6 +2904,29 @@ func (wm *WM) newMenu(title string, entries []menu.Entry, filter menu.FilterFunc
 	return m, nil
 }
 
+// serverTime returns the current server time. It makes a zero-length
+// change to a property of the dummy window and reads events until the
+// resulting PropertyNotify, whose timestamp is the server time; other
+// events are queued for the event loop. Because it reads events
+// itself, it must only be called before the event loop runs.
+func (wm *WM) serverTime() xproto.Timestamp {
+	dummy := wm.X.Dummy()
+	atom, err := xprop.Atm(wm.X, "_GWM_TIMESTAMP")
+	must(err)
+	must(xwindow.New(wm.X, dummy).Listen(xproto.EventMaskPropertyChange))
+	xproto.ChangeProperty(wm.X.Conn(), xproto.PropModeAppend, dummy, atom, xproto.AtomString, 8, 0, nil)
+	for {
+		ev, xerr := wm.X.Conn().WaitForEvent()
+		if ev == nil && xerr == nil {
+			log.Fatal("connection to X server closed")
+		}
+		if pn, ok := ev.(xproto.PropertyNotifyEvent); ok && pn.Window == dummy && pn.Atom == atom {
+			return pn.Time
+		}
+		xevent.Enqueue(wm.X, ev, xerr)
+	}
+}
+
 func (wm *WM) acquireOwnership(replace bool) error {
 	existingWM := false
 	var oldWin *xwindow.Window
@@ -1868,7 +2947,7 @@ func (wm *WM) acquireOwnership(replace bool) error {
 		err = oldWin.Listen(xproto.EventMaskStructureNotify)
 		must(err)
 	}
-	err = xproto.SetSelectionOwnerChecked(wm.X.Conn(), wm.X.Dummy(), selAtom, 0).Check()
+	err = xproto.SetSelectionOwnerChecked(wm.X.Conn(), wm.X.Dummy(), selAtom, wm.X.TimeGet()).Check()
 	must(err)
 
 	reply, err = xproto.GetSelectionOwner(wm.X.Conn(), selAtom).Reply()
@@ -1946,9 +3025,60 @@ func (wm *WM) Color(name string) int {
 	return color
 }
 
+// command returns the function that key bindings invoke by name.
+// Parameterised commands are named by their name and arguments,
+// separated by spaces.
+func (wm *WM) command(name string) (func(*WM), bool) {
+	if fn, ok := wm.commands[name]; ok {
+		return fn, true
+	}
+	args := strings.Fields(name)
+	if len(args) == 0 {
+		return nil, false
+	}
+	mk, ok := parameterised[args[0]]
+	if !ok {
+		return nil, false
+	}
+	fn, err := mk(args[1:])
+	if err != nil {
+		log.Printf("Invalid command %q: %s", name, err)
+		return nil, false
+	}
+	return fn, true
+}
+
+// parseOptions returns the options for parsing the configuration.
+func (wm *WM) parseOptions() config.Options {
+	return config.Options{
+		Function: func(name string) bool {
+			_, ok := wm.command(name)
+			return ok
+		},
+		Monitors: wm.monitors,
+	}
+}
+
+// grabKeys grabs the configured key bindings.
+func (wm *WM) grabKeys() {
+	for key, cmd := range wm.Config.Binds {
+		key, cmd := key, cmd
+		should(keybind.KeyPressFun(func(xu *xgbutil.XUtil, ev xevent.KeyPressEvent) {
+			if fn, ok := wm.command(cmd); ok {
+				fn(wm)
+			} else if c, ok := wm.Config.Commands[cmd]; ok {
+				execute(c)
+			}
+		}).Connect(wm.X, wm.Root.Id, key.ToXGB(), true))
+	}
+}
+
 func (wm *WM) Init(xu *xgbutil.XUtil) {
 	var err error
 	wm.X = xu
+	// Start with a real server time, so that we never use
+	// CurrentTime before the first event with a timestamp.
+	wm.X.TimeSet(wm.serverTime())
 	// TODO make replacing the WM optional
 	if err := wm.acquireOwnership(true); err != nil {
 		return
@@ -1980,61 +3110,84 @@ func (wm *WM) Init(xu *xgbutil.XUtil) {
 	wm.Root = wm.NewWindow(wm.X.RootWin())
 	xproto.ChangeWindowAttributes(wm.X.Conn(), wm.Root.Id, xproto.CwCursor,
 		[]uint32{uint32(wm.Cursors["normal"])})
-	var toMark *Window
-	for _, w := range wm.RelevantQueryTree() {
+	// Windows that were iconified when we last stopped, including
+	// the ones hidden for showing the desktop and swallowed
+	// terminals, are managed too. Create all windows first, so that
+	// restoring their state can refer to each other.
+	var wins []*Window
+	for _, w := range wm.relevantQueryTree(true) {
 		win := wm.NewWindow(w)
+		if !win.Mapped {
+			win.State = icccm.StateIconic
+		}
+		wins = append(wins, win)
+	}
+	var toMark *Window
+	for _, win := range wins {
 		win.Init()
-		if win.ContainsPointer() {
+		if win.Mapped && win.ContainsPointer() {
 			toMark = win
 		}
 	}
+	wm.restoreDesktop()
 
 	if toMark != nil {
 		toMark.markActive()
 	}
 
 	must(wm.Root.Listen(xproto.EventMaskStructureNotify, xproto.EventMaskSubstructureNotify,
-		xproto.EventMaskFocusChange, xproto.EventMaskSubstructureRedirect))
+		xproto.EventMaskFocusChange, xproto.EventMaskSubstructureRedirect, xproto.EventMaskEnterWindow))
 	xevent.MapRequestFun(wm.MapRequest).Connect(xu, wm.Root.Id)
 	xevent.ConfigureRequestFun(wm.ConfigureRequest).Connect(xu, wm.Root.Id)
+	xevent.ConfigureNotifyFun(wm.RootConfigureNotify).Connect(xu, wm.Root.Id)
 
-	for key, cmd := range wm.Config.Binds {
-		key, cmd := key, cmd
-		should(keybind.KeyPressFun(func(xu *xgbutil.XUtil, ev xevent.KeyPressEvent) {
-			if fn, ok := commands[cmd]; ok {
-				fn(wm)
-			} else {
-				execute(cmd)
-			}
-		}).Connect(wm.X, wm.Root.Id, key.ToXGB(), true))
-	}
+	wm.grabKeys()
 
 	should(ewmh.NumberOfDesktopsSet(wm.X, 1))
 	should(ewmh.CurrentDesktopSet(wm.X, 0))
 	should(ewmh.DesktopViewportSet(wm.X, nil))
+	should(ewmh.ShowingDesktopSet(wm.X, wm.showingDesktop))
 	should(ewmh.SupportedSet(wm.X, []string{
-		// "WM_TAKE_FOCUS",
+		"WM_TAKE_FOCUS",
 		"_NET_ACTIVE_WINDOW",
 		"_NET_WM_MOVERESIZE",
 		"_NET_SUPPORTED",
 		"_NET_NUMBER_OF_DESKTOPS",
 		"_NET_CURRENT_DESKTOP",
 		"_NET_SUPPORTING_WM_CHECK",
+		"_NET_SHOWING_DESKTOP",
 		"_NET_WM_NAME",
 		"_NET_WM_STATE",
 		"_NET_WM_STATE_MAXIMIZED_VERT",
 		"_NET_WM_STATE_MAXIMIZED_HORZ",
 		"_NET_WM_STATE_FULLSCREEN",
+		"_NET_WM_STATE_SKIP_TASKBAR",
+		"_NET_WM_STATE_SKIP_PAGER",
+		"_NET_WM_STATE_MODAL",
+		"_NET_WM_STATE_HIDDEN",
 		"_NET_WM_ALLOWED_ACTIONS",
 		"_NET_WM_ACTION_FULLSCREEN",
 		"_NET_WM_ACTION_MAXIMIZE_VERT",
 		"_NET_WM_ACTION_MAXIMIZE_HORZ",
+		"_NET_WM_ACTION_MOVE",
+		"_NET_WM_ACTION_RESIZE",
+		"_NET_WM_ACTION_ABOVE",
+		"_NET_WM_ACTION_BELOW",
+		"_NET_WM_ACTION_CLOSE",
 	}))
 
 	must(ewmh.SupportingWmCheckSet(wm.X, wm.Root.Id, wm.X.Dummy()))
 	must(ewmh.SupportingWmCheckSet(wm.X, wm.X.Dummy(), wm.X.Dummy()))
 	must(ewmh.WmNameSet(wm.X, wm.X.Dummy(), "gwm"))
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			wm.chFn <- wm.Reload
+		}
+	}()
+
 	before, after, quit := xevent.MainPing(wm.X)
 	for {
 		select {
@@ -2048,27 +3201,80 @@ func (wm *WM) Init(xu *xgbutil.XUtil) {
 	}
 }
 
+// checkConfig checks the configuration file at path and validates
+// its key bindings against the keyboard mapping, printing all errors.
+// It returns the exit status.
+func checkConfig(path string) int {
+	wm := &WM{commands: commands, monitors: 1}
+	xu, xerr := xgbutil.NewConn()
+	if xerr == nil {
+		wm.monitors = monitors(xu)
+	}
+	status := 0
+	cfg, err := config.ParseFile(path, wm.parseOptions())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		status = 1
+	}
+	if cfg == nil {
+		return status
+	}
+	if xerr != nil {
+		fmt.Fprintln(os.Stderr, "Not checking key names, could not connect to X:", xerr)
+		return status
+	}
+	keybind.Initialize(xu)
+	err = cfg.CheckKeys(func(key string) error {
+		_, codes, err := keybind.ParseString(xu, key)
+		if err != nil {
+			return err
+		}
+		if len(codes) == 0 {
+			return fmt.Errorf("unknown key %q", key)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		status = 1
+	}
+	return status
+}
+
 func main() {
-	log.Println("Starting gwm")
+	check := flag.Bool("n", false, "check the configuration file and exit")
+	flag.Parse()
 	p := "./cwmrc"
-	if len(os.Args) > 1 {
-		p = os.Args[1]
+	if flag.NArg() > 0 {
+		p = flag.Arg(0)
 	}
-	f, _ := os.Open(p)
-	cfg, err := config.Parse(f)
-	if err != nil {
-		panic(err)
+	if *check {
+		os.Exit(checkConfig(p))
 	}
+
+	log.Println("Starting gwm")
+	xu, err := xgbutil.NewConn()
+	must(err)
 	wm := &WM{
-		Config: cfg,
+		configPath: p,
+		commands:   commands,
+		monitors:   monitors(xu),
 		// FIXME all of the make() stuff should be in the Init() method
 		Cursors: make(map[string]xproto.Cursor),
 		Windows: make(map[xproto.Window]*Window),
 		chFn:    make(chan func()),
 		colors:  make(map[string]int),
 	}
-	xu, err := xgbutil.NewConn()
-	must(err)
+	cfg, err := config.ParseFile(p, wm.parseOptions())
+	if os.IsNotExist(err) {
+		log.Printf("Configuration file %s doesn't exist, using defaults", p)
+		cfg, err = config.Parse(strings.NewReader(""), wm.parseOptions())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	wm.Config = cfg
 
 	/*
 		laddr, err := net.ResolveUnixAddr("unix", "/tmp/gwm-1")
@@ -2115,12 +3321,41 @@ func winmovefunc(xf, yf int) func(*WM) {
 			return
 		}
 		win := wm.CurWindow
-		dx := xf * wm.Config.MoveAmount
-		dy := yf * wm.Config.MoveAmount
-		win.Move(win.Layout.X+dx, win.Layout.Y+dy)
-		wm.WarpPointerRel(dx, dy)
-		// TODO apply snapping
+		x, y := win.Layout.X, win.Layout.Y
+		win.Layout.X += xf * wm.Config.MoveAmount
+		win.Layout.Y += yf * wm.Config.MoveAmount
+		// Only snap in the direction of movement, or the window
+		// could never be moved away from an edge.
+		win.snapMove(xf, yf)
+		win.move()
+		wm.WarpPointerRel(win.Layout.X-x, win.Layout.Y-y)
+	}
+}
+
+func wingridfunc(xf, yf int) func(*WM) {
+	cells := anchorCells(xf, yf)
+	return func(wm *WM) {
+		if wm.CurWindow == nil {
+			return
+		}
+		wm.CurWindow.SnapToGrid(cells)
+	}
+}
+
+// gridfunc returns the grid command for its arguments, which places
+// the current window in a span of cells of a grid, for example
+// "grid 3 1 1 0 1 1" for the middle third.
+func gridfunc(args []string) (func(*WM), error) {
+	c, err := parseGridCell(args)
+	if err != nil {
+		return nil, err
 	}
+	return func(wm *WM) {
+		if wm.CurWindow == nil {
+			return
+		}
+		wm.CurWindow.PlaceInGrid(c)
+	}, nil
 }
 
 func winmaximizefunc(state MaximizedState) func(*WM) {
@@ -2128,7 +3363,16 @@ func winmaximizefunc(state MaximizedState) func(*WM) {
 		if wm.CurWindow == nil {
 			return
 		}
-		wm.CurWindow.Maximize(state)
+		wm.CurWindow.ToggleMaximize(state)
+	}
+}
+
+func windirfunc(fn func(*Window, Direction), dir Direction) func(*WM) {
+	return func(wm *WM) {
+		if wm.CurWindow == nil {
+			return
+		}
+		fn(wm.CurWindow, dir)
 	}
 }
 
@@ -2160,6 +3404,12 @@ type Layout struct {
 	State MaximizedState
 }
 
+// parameterised are the commands that take arguments, and the
+// functions that make the command for the arguments.
+var parameterised = map[string]func(args []string) (func(*WM), error){
+	"grid": gridfunc,
+}
+
 var commands = map[string]func(wm *WM){
 	"lower":        winfunc((*Window).Lower),
 	"raise":        winfunc((*Window).Raise),
@@ -2181,6 +3431,14 @@ var commands = map[string]func(wm *WM){
 	"bigmoveleft":  winmovefunc(-10, 0),
 	"moveright":    winmovefunc(1, 0),
 	"bigmoveright": winmovefunc(10, 0),
+	"focusleft":    windirfunc((*Window).FocusNeighbour, DirLeft),
+	"focusright":   windirfunc((*Window).FocusNeighbour, DirRight),
+	"focusup":      windirfunc((*Window).FocusNeighbour, DirUp),
+	"focusdown":    windirfunc((*Window).FocusNeighbour, DirDown),
+	"swapleft":     windirfunc((*Window).SwapNeighbour, DirLeft),
+	"swapright":    windirfunc((*Window).SwapNeighbour, DirRight),
+	"swapup":       windirfunc((*Window).SwapNeighbour, DirUp),
+	"swapdown":     windirfunc((*Window).SwapNeighbour, DirDown),
 	"maximize":     winmaximizefunc(MaximizedFull),
 	"vmaximize":    winmaximizefunc(MaximizedV),
 	"hmaximize":    winmaximizefunc(MaximizedH),
@@ -2190,9 +3448,36 @@ var commands = map[string]func(wm *WM){
 	"below":        winlayerfunc(LayerBelow),
 	"delete":       winfunc((*Window).Delete),
 	"poplayout":    winfunc((*Window).PopLayout),
+	"redolayout":   winfunc((*Window).RedoLayout),
+	"undoall":      (*WM).UndoAll,
 	"cycle":        (*WM).CycleScreens,
+	"showdesktop":  (*WM).ToggleShowDesktop,
+
+	"snapleft":      wingridfunc(-1, 0),
+	"snapright":     wingridfunc(1, 0),
+	"snapup":        wingridfunc(0, -1),
+	"snapdown":      wingridfunc(0, 1),
+	"snapupleft":    wingridfunc(-1, -1),
+	"snapupright":   wingridfunc(1, -1),
+	"snapdownleft":  wingridfunc(-1, 1),
+	"snapdownright": wingridfunc(1, 1),
+	"htile":         winfunc((*Window).HTile),
+	"vtile":         winfunc((*Window).VTile),
+	"gridtile":      (*WM).GridTile,
+
+	"tilenone":        wmtilingfunc(config.TileNone),
+	"tilemasterstack": wmtilingfunc(config.TileMasterStack),
+	"tilemonocle":     wmtilingfunc(config.TileMonocle),
+	"tilegrid":        wmtilingfunc(config.TileGrid),
+	"tilenext":        (*WM).NextTiling,
+	"growmaster":      wmmasterfunc(5, 0),
+	"shrinkmaster":    wmmasterfunc(-5, 0),
+	"addmaster":       wmmasterfunc(0, 1),
+	"removemaster":    wmmasterfunc(0, -1),
+	"togglefloating":  winfunc((*Window).ToggleFloating),
 
 	"debug":   (*WM).debug,
+	"reload":  (*WM).Reload,
 	"restart": (*WM).Restart,
 
 	"terminal": func(wm *WM) {
@@ -2219,9 +3504,11 @@ var commands = map[string]func(wm *WM){
 	},
 
 	"search": (*WM).windowSearchMenu,
+
+	"savesnapshot":    (*WM).saveSnapshotMenu,
+	"restoresnapshot": (*WM).restoreSnapshotMenu,
 }
 
 // TODO watch for wm_normal_hints changes
 // TODO remove wm_state when withdrawing
 // TODO unset _NET_DESKTOP_NAMES
-// TODO set allowed actions
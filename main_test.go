@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/xgbutil/icccm"
+	"github.com/BurntSushi/xgbutil/xwindow"
+)
+
+func TestIgnoreUnmap(t *testing.T) {
+	wm := &WM{Root: &Window{Window: &xwindow.Window{Id: 1}}}
+	win := &Window{Window: &xwindow.Window{Id: 2}, wm: wm, State: icccm.StateNormal}
+	const root, self = 1, 2
+
+	// The client unmapping a normal window withdraws it once.
+	if !win.ignoreUnmap(root) {
+		t.Error("root's copy of an unmap of a normal window withdraws it")
+	}
+	if win.ignoreUnmap(self) {
+		t.Error("window's copy of an unmap of a normal window doesn't withdraw it")
+	}
+
+	// Iconifying the window unmaps it, which mustn't withdraw it.
+	win.State = icccm.StateIconic
+	win.ignoreUnmaps++
+	win.ignoreRootUnmaps++
+	if !win.ignoreUnmap(self) || !win.ignoreUnmap(root) {
+		t.Error("unmap caused by iconifying withdraws the window")
+	}
+
+	// The client withdraws the iconic window with a synthetic
+	// UnmapNotify sent to the root.
+	if win.ignoreUnmap(root) {
+		t.Error("synthetic UnmapNotify doesn't withdraw iconic window")
+	}
+}
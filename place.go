@@ -0,0 +1,264 @@
+package main
+
+import (
+	"log"
+
+	"github.com/BurntSushi/xgbutil"
+	"github.com/BurntSushi/xgbutil/keybind"
+	"github.com/BurntSushi/xgbutil/mousebind"
+	"github.com/BurntSushi/xgbutil/xevent"
+
+	"honnef.co/go/gwm/config"
+	"honnef.co/go/gwm/internal/quadtree"
+)
+
+const (
+	// cascadeStep is the distance between two cascaded windows.
+	cascadeStep = 24
+	// dragThreshold is the distance the pointer has to move before
+	// a click becomes a drag.
+	dragThreshold = 5
+)
+
+// workarea returns the area of the screen that windows may occupy.
+func (wm *WM) workarea(screen Geometry) Geometry {
+	return screen.subtractGap(wm.Config.Gap)
+}
+
+// outerSize returns the window's size including its border.
+func (win *Window) outerSize() (w, h int) {
+	return win.Layout.Width + 2*win.BorderWidth, win.Layout.Height + 2*win.BorderWidth
+}
+
+// keepInside moves the window so that it is fully inside area,
+// shrinking it if it is larger than area.
+func (win *Window) keepInside(area Geometry) {
+	w, h := win.outerSize()
+	if w > area.Width {
+		win.Layout.Width -= w - area.Width
+		w = area.Width
+	}
+	if h > area.Height {
+		win.Layout.Height -= h - area.Height
+		h = area.Height
+	}
+	if win.Layout.X+w > area.X+area.Width {
+		win.Layout.X = area.X + area.Width - w
+	}
+	if win.Layout.Y+h > area.Y+area.Height {
+		win.Layout.Y = area.Y + area.Height - h
+	}
+	if win.Layout.X < area.X {
+		win.Layout.X = area.X
+	}
+	if win.Layout.Y < area.Y {
+		win.Layout.Y = area.Y
+	}
+}
+
+// place positions a newly mapped window according to the configured
+// placement policy.
+func (win *Window) place() {
+	wm := win.wm
+	area := wm.workarea(wm.CurrentScreen())
+	w, h := win.outerSize()
+
+	switch wm.Config.Placement {
+	case config.PlacePointer:
+		ptr := wm.PointerPos()
+		win.Layout.X = ptr.X - w/2
+		win.Layout.Y = ptr.Y - h/2
+	case config.PlaceCenter:
+		win.Layout.X = area.X + (area.Width-w)/2
+		win.Layout.Y = area.Y + (area.Height-h)/2
+	case config.PlaceCascade:
+		off := wm.cascadeOffset
+		if off+w > area.Width || off+h > area.Height {
+			off = 0
+		}
+		win.Layout.X = area.X + off
+		win.Layout.Y = area.Y + off
+		wm.cascadeOffset = off + cascadeStep
+	case config.PlaceSmart:
+		p := win.smartPosition(area)
+		win.Layout.X = p.X
+		win.Layout.Y = p.Y
+	}
+	win.keepInside(area)
+}
+
+// smartPosition finds the position in area at which the window
+// overlaps the least with other windows. Candidates are the corners of
+// the area and the positions next to the edges of other windows.
+func (win *Window) smartPosition(area Geometry) Point {
+	wm := win.wm
+	w, h := win.outerSize()
+
+	size := 0
+	for _, sc := range wm.Screens() {
+		if sc.X+sc.Width > size {
+			size = sc.X + sc.Width
+		}
+		if sc.Y+sc.Height > size {
+			size = sc.Y + sc.Height
+		}
+	}
+	q := quadtree.New(size)
+
+	xs := []int{area.X, area.X + area.Width - w}
+	ys := []int{area.Y, area.Y + area.Height - h}
+	for _, owin := range wm.MappedWindows() {
+		if owin == win || owin.HasType("_NET_WM_WINDOW_TYPE_DESKTOP") {
+			continue
+		}
+		ow, oh := owin.outerSize()
+		q.SetRegion(quadtree.Region{
+			X:      owin.Layout.X,
+			Y:      owin.Layout.Y,
+			Width:  ow,
+			Height: oh,
+		}, int(owin.Id))
+		xs = append(xs, owin.Layout.X+ow, owin.Layout.X-w)
+		ys = append(ys, owin.Layout.Y+oh, owin.Layout.Y-h)
+	}
+
+	best := Point{area.X, area.Y}
+	bestOverlap := -1
+	for _, y := range ys {
+		if y < area.Y || y+h > area.Y+area.Height {
+			continue
+		}
+		for _, x := range xs {
+			if x < area.X || x+w > area.X+area.Width {
+				continue
+			}
+			overlap := w*h - q.Area(quadtree.Region{X: x, Y: y, Width: w, Height: h}, 0)
+			if bestOverlap == -1 || overlap < bestOverlap ||
+				(overlap == bestOverlap && (y < best.Y || (y == best.Y && x < best.X))) {
+				best = Point{x, y}
+				bestOverlap = overlap
+			}
+		}
+	}
+	return best
+}
+
+// manualPlacement reports whether the user wants to place the window
+// with the mouse.
+func (win *Window) manualPlacement() bool {
+	name, class := win.Class()
+	for _, c := range win.wm.Config.ManualPlace {
+		if c == "all" || c == class || c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// PlaceInteractively lets the user place the window with the mouse.
+// Clicking places the window at its requested size, dragging sets
+// both its position and size. Escape keeps the position chosen by
+// the placement policy. done is called once the window has been
+// placed. PlaceInteractively reports whether the interaction could
+// be started.
+func (win *Window) PlaceInteractively(done func()) bool {
+	wm := win.wm
+	if wm.placing != nil {
+		return false
+	}
+	bw := win.BorderWidth
+	if bw < 1 {
+		bw = 1
+	}
+	r, err := wm.NewRectangle(uint16(bw), wm.Color(wm.Config.Colors["activeborder"]))
+	if err != nil {
+		log.Println("couldn't create rectangle:", err)
+		return false
+	}
+	r.Show()
+	ok, err := mousebind.GrabPointer(wm.X, r.Id(), 0, 0)
+	if err != nil || !ok {
+		log.Println("couldn't grab pointer:", err)
+		r.Destroy()
+		return false
+	}
+	if err := keybind.GrabKeyboard(wm.X, r.Id()); err != nil {
+		log.Println("couldn't grab keyboard:", err)
+	}
+	wm.placing = win
+
+	w, h := win.Layout.Width, win.Layout.Height
+	var start *Point
+	geometry := func(p Point) Geometry {
+		if start == nil {
+			return Geometry{X: p.X, Y: p.Y, Width: w, Height: h}
+		}
+		x0, x1 := start.X, p.X
+		if x1 < x0 {
+			x0, x1 = x1, x0
+		}
+		y0, y1 := start.Y, p.Y
+		if y1 < y0 {
+			y0, y1 = y1, y0
+		}
+		g := Geometry{X: x0, Y: y0, Width: x1 - x0 - 2*win.BorderWidth, Height: y1 - y0 - 2*win.BorderWidth}
+		if g.Width < 1 {
+			g.Width = 1
+		}
+		if g.Height < 1 {
+			g.Height = 1
+		}
+		return g
+	}
+	update := func(p Point) {
+		g := geometry(p)
+		r.MoveAndResize(g.X+win.BorderWidth, g.Y+win.BorderWidth, g.Width, g.Height)
+	}
+
+	finish := func(g *Geometry) {
+		xevent.Detach(wm.X, r.Id())
+		keybind.Detach(wm.X, r.Id())
+		mousebind.UngrabPointer(wm.X)
+		keybind.UngrabKeyboard(wm.X)
+		r.Destroy()
+		wm.placing = nil
+		if wm.Windows[win.Id] != win {
+			// The window went away while we were placing it.
+			return
+		}
+		if g != nil {
+			win.Layout.Geometry = *g
+			win.keepInside(wm.workarea(screenForPoint(wm.Screens(), Point{g.X, g.Y})))
+		}
+		done()
+	}
+
+	xevent.ButtonPressFun(func(xu *xgbutil.XUtil, ev xevent.ButtonPressEvent) {
+		start = &Point{int(ev.RootX), int(ev.RootY)}
+	}).Connect(wm.X, r.Id())
+	xevent.ButtonReleaseFun(func(xu *xgbutil.XUtil, ev xevent.ButtonReleaseEvent) {
+		if start == nil {
+			return
+		}
+		p := Point{int(ev.RootX), int(ev.RootY)}
+		var g Geometry
+		if abs(p.X-start.X) < dragThreshold && abs(p.Y-start.Y) < dragThreshold {
+			g = Geometry{X: start.X, Y: start.Y, Width: w, Height: h}
+		} else {
+			g = geometry(p)
+		}
+		finish(&g)
+	}).Connect(wm.X, r.Id())
+	xevent.MotionNotifyFun(func(xu *xgbutil.XUtil, ev xevent.MotionNotifyEvent) {
+		update(Point{int(ev.RootX), int(ev.RootY)})
+	}).Connect(wm.X, r.Id())
+	fn := keybind.KeyPressFun(func(xu *xgbutil.XUtil, ev xevent.KeyPressEvent) {
+		finish(nil)
+	})
+	if err := fn.Connect(wm.X, r.Id(), "Escape", true); err != nil {
+		log.Println("couldn't register keybind:", err)
+	}
+
+	update(wm.PointerPos())
+	return true
+}
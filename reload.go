@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil/keybind"
+	"github.com/BurntSushi/xgbutil/mousebind"
+	"github.com/BurntSushi/xgbutil/xwindow"
+
+	"honnef.co/go/gwm/config"
+	"honnef.co/go/gwm/draw"
+)
+
+// messageTimeout is how long messages are shown for.
+const messageTimeout = 10 * time.Second
+
+// ShowMessage shows lines of text in the top left corner of the
+// current screen for messageTimeout.
+func (wm *WM) ShowMessage(lines ...string) {
+	xw, err := xwindow.Generate(wm.X)
+	if err != nil {
+		log.Println("couldn't create message window:", err)
+		return
+	}
+	sc := wm.CurrentScreen().subtractGap(wm.Config.Gap)
+	if err := xw.CreateChecked(wm.Root.Id, sc.X, sc.Y, 1, 1,
+		xproto.CwBackPixel|xproto.CwOverrideRedirect, 0xFFFFFF, 1); err != nil {
+		log.Println("couldn't create message window:", err)
+		xw.Destroy()
+		return
+	}
+	win := &Window{wm: wm, Window: xw, gcs: make(draw.GCs)}
+	xw.Map()
+	render := func() (w, h int) {
+		for _, line := range lines {
+			lw, lh := draw.Text(win, line, wm.font, 0, 0xFFFFFF, 0, h)
+			if lw > w {
+				w = lw
+			}
+			h += lh
+		}
+		return w, h
+	}
+	// Render once to find the size, and again after resizing.
+	xw.Resize(render())
+	render()
+	time.AfterFunc(messageTimeout, func() {
+		wm.chFn <- xw.Destroy
+	})
+}
+
+// Reload re-reads the configuration file and applies the changes
+// without restarting. If the file cannot be parsed, the current
+// configuration is kept and the error is shown.
+func (wm *WM) Reload() {
+	log.Println("Reloading configuration from", wm.configPath)
+	cfg, err := config.ParseFile(wm.configPath, wm.parseOptions())
+	if err != nil {
+		log.Println("Could not reload configuration:", err)
+		wm.ShowMessage(strings.Split(err.Error(), "\n")...)
+		return
+	}
+	old := wm.Config
+	wm.Config = cfg
+
+	if !reflect.DeepEqual(old.Binds, cfg.Binds) {
+		keybind.Detach(wm.X, wm.Root.Id)
+		wm.grabKeys()
+	}
+
+	wins := wm.clientWindows()
+	if !reflect.DeepEqual(old.MouseBinds, cfg.MouseBinds) || old.Focus != cfg.Focus {
+		for _, win := range wins {
+			win.ungrabFocusClick()
+			mousebind.Detach(wm.X, win.Id)
+			win.grabButtons()
+			if cfg.Focus == config.FocusClick && win != wm.CurWindow {
+				win.grabFocusClick()
+			}
+		}
+	}
+
+	if old.BorderWidth != cfg.BorderWidth || !reflect.DeepEqual(old.Colors, cfg.Colors) {
+		for _, win := range wins {
+			if (win.Layout.State & Fullscreen) == 0 {
+				win.SetBorderWidth(win.normalBorderWidth())
+			}
+			color := "inactiveborder"
+			if win == wm.CurWindow {
+				color = "activeborder"
+			}
+			win.SetBorderColor(wm.Color(cfg.Colors[color]))
+		}
+	}
+
+	if old.Gap != cfg.Gap || old.BorderWidth != cfg.BorderWidth {
+		wm.ScreensChanged()
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil/icccm"
+	"github.com/BurntSushi/xgbutil/xprop"
+)
+
+// statePropName is the gwm-private property that window state is
+// saved in across restarts.
+const statePropName = "_GWM_STATE"
+
+// savedState is the state of a window that cannot be recovered from
+// the X server or the window's EWMH properties alone. Whether the
+// window is iconic is kept in its WM_STATE.
+type savedState struct {
+	Layer             Layer
+	Frozen            bool
+	Floating          bool
+	LayoutStack       []Layout
+	RedoStack         []Layout
+	UnfullscreenGeom  Geometry
+	UnfullscreenLayer Layer
+	UnmaximizeGeom    Geometry
+	SkipSearch        bool
+	NoFocus           bool
+	RuleBorderWidth   *int
+	// SwallowedBy is the window that swallowed the terminal, if any.
+	SwallowedBy xproto.Window
+}
+
+// savedDesktop is the state of the show-desktop mode, which is saved
+// on the root window while showing the desktop.
+type savedDesktop struct {
+	Stack []xproto.Window
+	Focus xproto.Window
+}
+
+// saveState stores the window's state in a property on the window, so
+// that it survives a restart.
+func (win *Window) saveState() error {
+	s := savedState{
+		Layer:             win.Layer,
+		Frozen:            win.frozen,
+		Floating:          win.floating,
+		LayoutStack:       win.LayoutStack,
+		RedoStack:         win.RedoStack,
+		UnfullscreenGeom:  win.unfullscreenGeom,
+		UnfullscreenLayer: win.unfullscreenLayer,
+		UnmaximizeGeom:    win.unmaximizeGeom,
+		SkipSearch:        win.skipSearch,
+		NoFocus:           win.noFocus,
+		RuleBorderWidth:   win.ruleBorderWidth,
+	}
+	if win.swallowedBy != nil {
+		s.SwallowedBy = win.swallowedBy.Id
+	}
+	return win.wm.putState(win.Id, s)
+}
+
+// putState stores v in the state property of the window id.
+func (wm *WM) putState(id xproto.Window, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return xprop.ChangeProp(wm.X, id, 8, statePropName, "UTF8_STRING", b)
+}
+
+// takeState returns the state stored in the window id and removes the
+// property, so that it won't be applied again later.
+func (wm *WM) takeState(id xproto.Window) string {
+	v, err := xprop.PropValStr(xprop.GetProperty(wm.X, id, statePropName))
+	if err != nil || v == "" {
+		return ""
+	}
+	if atom, err := xprop.Atm(wm.X, statePropName); err == nil {
+		xproto.DeleteProperty(wm.X.Conn(), id, atom)
+	}
+	return v
+}
+
+// restoreState restores the state saved by saveState and removes the
+// property, so that it won't be applied again when the window is
+// mapped again later. It must be called after the window's
+// _NET_WM_STATE has been applied, and after all windows that are
+// being managed at startup have been created.
+func (win *Window) restoreState() {
+	v := win.wm.takeState(win.Id)
+	if v == "" {
+		return
+	}
+	var s savedState
+	if err := json.Unmarshal([]byte(v), &s); err != nil {
+		LogWindowEvent(win, "Could not restore state: "+err.Error())
+		return
+	}
+	LogWindowEvent(win, "Restoring state from before restart")
+	win.LayoutStack = s.LayoutStack
+	win.RedoStack = s.RedoStack
+	win.unfullscreenGeom = s.UnfullscreenGeom
+	win.unfullscreenLayer = s.UnfullscreenLayer
+	win.unmaximizeGeom = s.UnmaximizeGeom
+	win.floating = s.Floating
+	win.frozen = s.Frozen
+	win.updateAllowedActions()
+	win.SetLayer(s.Layer)
+	win.skipSearch = s.SkipSearch
+	win.noFocus = s.NoFocus
+	win.ruleBorderWidth = s.RuleBorderWidth
+	if (win.Layout.State & Fullscreen) == 0 {
+		win.SetBorderWidth(win.normalBorderWidth())
+	}
+	if by, ok := win.wm.Windows[s.SwallowedBy]; ok && by != win && win.State == icccm.StateIconic {
+		win.swallowedBy = by
+		by.swallowed = win
+	}
+}
+
+// saveState saves the state of all managed windows and of the
+// show-desktop mode before a restart.
+func (wm *WM) saveState() {
+	for _, win := range wm.clientWindows() {
+		if err := win.saveState(); err != nil {
+			log.Printf("Could not save state of window %d: %s", win.Id, err)
+		}
+	}
+	if !wm.showingDesktop {
+		return
+	}
+	var s savedDesktop
+	for _, win := range wm.desktopStack {
+		s.Stack = append(s.Stack, win.Id)
+	}
+	if wm.desktopFocus != nil {
+		s.Focus = wm.desktopFocus.Id
+	}
+	if err := wm.putState(wm.Root.Id, s); err != nil {
+		log.Println("Could not save show-desktop state:", err)
+	}
+}
+
+// restoreDesktop restores the show-desktop mode saved by saveState. It
+// must be called after the windows have been managed.
+func (wm *WM) restoreDesktop() {
+	v := wm.takeState(wm.Root.Id)
+	if v == "" {
+		return
+	}
+	var s savedDesktop
+	if err := json.Unmarshal([]byte(v), &s); err != nil {
+		log.Println("Could not restore show-desktop state:", err)
+		return
+	}
+	log.Println("Still showing desktop after restart")
+	wm.showingDesktop = true
+	for _, id := range s.Stack {
+		if win, ok := wm.Windows[id]; ok {
+			wm.desktopStack = append(wm.desktopStack, win)
+		}
+	}
+	wm.desktopFocus = wm.Windows[s.Focus]
+}
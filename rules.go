@@ -0,0 +1,108 @@
+package main
+
+import (
+	"github.com/BurntSushi/xgbutil/xprop"
+
+	"honnef.co/go/gwm/config"
+)
+
+// Role returns the window's WM_WINDOW_ROLE.
+func (win *Window) Role() string {
+	role, err := xprop.PropValStr(xprop.GetProperty(win.wm.X, win.Id, "WM_WINDOW_ROLE"))
+	if err != nil {
+		return ""
+	}
+	return role
+}
+
+// applyRules applies the rules that match the window, in the order
+// they were configured. It reports whether a rule positioned the
+// window, which then mustn't be placed automatically.
+func (win *Window) applyRules() bool {
+	instance, class := win.Class()
+	title, role, types := win.Name(), win.Role(), win.Types()
+	if len(types) == 0 {
+		types = []string{"_NET_WM_WINDOW_TYPE_NORMAL"}
+	}
+	// Windows that asked to be fullscreen keep their border width,
+	// geometry and layer for when they leave fullscreen.
+	fullscreen := (win.Layout.State & Fullscreen) > 0
+	placed := false
+	for i := range win.wm.Config.Rules {
+		r := &win.wm.Config.Rules[i]
+		if !r.Matches(instance, class, title, role, types) {
+			continue
+		}
+		LogWindowEvent(win, "Applying rule at "+r.Pos.String())
+		if r.BorderWidth != nil {
+			bw := *r.BorderWidth
+			win.ruleBorderWidth = &bw
+			if !fullscreen {
+				win.SetBorderWidth(bw)
+			}
+		}
+		if r.Geometry != nil && !fullscreen && win.applyGeometry(*r.Geometry) {
+			placed = true
+		}
+		if layer, ok := ruleLayers[r.Layer]; ok {
+			if fullscreen {
+				win.unfullscreenLayer = layer
+			} else {
+				win.SetLayer(layer)
+			}
+		}
+		if r.Frozen {
+			win.Freeze()
+		}
+		if r.SkipSearch {
+			win.skipSearch = true
+		}
+		if r.NoFocus {
+			win.noFocus = true
+		}
+		if r.Fullscreen {
+			win.Fullscreen()
+			fullscreen = true
+		}
+	}
+	return placed
+}
+
+var ruleLayers = map[config.Layer]Layer{
+	config.LayerBelow:  LayerBelow,
+	config.LayerNormal: LayerNormal,
+	config.LayerAbove:  LayerAbove,
+}
+
+// applyGeometry applies an X geometry specification, relative to the
+// work area of the current screen, keeping the window inside it. It
+// reports whether the geometry positioned the window.
+func (win *Window) applyGeometry(g config.Geometry) bool {
+	if g.Width > 0 {
+		win.Layout.Width, win.Layout.Height = win.constrainSize(g.Width, g.Height)
+	}
+	if !g.Position {
+		return false
+	}
+	area := win.wm.workarea(win.wm.CurrentScreen())
+	w, h := win.outerSize()
+	win.Layout.X = area.X + g.X
+	if g.XNeg {
+		win.Layout.X = area.X + area.Width - w - g.X
+	}
+	win.Layout.Y = area.Y + g.Y
+	if g.YNeg {
+		win.Layout.Y = area.Y + area.Height - h - g.Y
+	}
+	win.keepInside(area)
+	return true
+}
+
+// normalBorderWidth returns the border width of the window when it
+// isn't fullscreen: the one set by a rule, or the configured one.
+func (win *Window) normalBorderWidth() int {
+	if win.ruleBorderWidth != nil {
+		return *win.ruleBorderWidth
+	}
+	return win.wm.Config.BorderWidth
+}
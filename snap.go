@@ -0,0 +1,119 @@
+package main
+
+// edges returns the outer edges of the window, including its border.
+func (win *Window) edges() (left, top, right, bottom int) {
+	w, h := win.outerSize()
+	return win.Layout.X, win.Layout.Y, win.Layout.X + w, win.Layout.Y + h
+}
+
+// snapTargets returns the coordinates that the window's vertical (xs)
+// and horizontal (ys) edges are attracted to: the edges of the work
+// area and those of the other visible windows.
+func (win *Window) snapTargets() (xs, ys []int) {
+	area := win.wm.workarea(win.Screen())
+	xs = []int{area.X, area.X + area.Width}
+	ys = []int{area.Y, area.Y + area.Height}
+
+	left, top, right, bottom := win.edges()
+	for _, other := range win.wm.VisibleWindows() {
+		if other == win || other.HasType("_NET_WM_WINDOW_TYPE_DESKTOP") {
+			continue
+		}
+		ol, ot, or, ob := other.edges()
+		// Only windows that are level with the window attract it,
+		// or else edges far away would be snapped to.
+		if ot <= bottom && ob >= top {
+			xs = append(xs, ol, or)
+		}
+		if ol <= right && or >= left {
+			ys = append(ys, ot, ob)
+		}
+	}
+	return xs, ys
+}
+
+// snapOffset returns the smallest offset that moves one of edges onto
+// one of targets, or 0 if none is within snapdist. If dir is non-zero,
+// only offsets with the same sign are considered.
+func snapOffset(edges, targets []int, snapdist, dir int) int {
+	best := snapdist + 1
+	for _, e := range edges {
+		for _, t := range targets {
+			d := t - e
+			if d*dir < 0 {
+				continue
+			}
+			if abs(d) < abs(best) {
+				best = d
+			}
+		}
+	}
+	if abs(best) > snapdist {
+		return 0
+	}
+	return best
+}
+
+// snapMove moves the window so that its edges line up with nearby
+// edges. dx and dy restrict snapping to one direction per axis; an
+// axis is not snapped at all if it is being moved along the other.
+// Zero for both allows snapping in any direction.
+func (win *Window) snapMove(dx, dy int) {
+	snapdist := win.wm.Config.Snapdist
+	if snapdist <= 0 {
+		return
+	}
+	xs, ys := win.snapTargets()
+	left, top, right, bottom := win.edges()
+	free := dx == 0 && dy == 0
+	if free || dx != 0 {
+		win.Layout.X += snapOffset([]int{left, right}, xs, snapdist, dx)
+	}
+	if free || dy != 0 {
+		win.Layout.Y += snapOffset([]int{top, bottom}, ys, snapdist, dy)
+	}
+}
+
+// snapResize resizes the window so that the edges being dragged by
+// corner line up with nearby edges, as far as the window's size hints
+// allow.
+func (win *Window) snapResize(c corner) {
+	snapdist := win.wm.Config.Snapdist
+	if snapdist <= 0 {
+		return
+	}
+	xs, ys := win.snapTargets()
+	left, top, right, bottom := win.edges()
+	if c&cornerW != 0 {
+		if d := snapOffset([]int{left}, xs, snapdist, 0); d < win.Layout.Width {
+			win.Layout.X += d
+			win.Layout.Width -= d
+		}
+	}
+	if c&cornerE != 0 {
+		if d := snapOffset([]int{right}, xs, snapdist, 0); -d < win.Layout.Width {
+			win.Layout.Width += d
+		}
+	}
+	if c&cornerN != 0 {
+		if d := snapOffset([]int{top}, ys, snapdist, 0); d < win.Layout.Height {
+			win.Layout.Y += d
+			win.Layout.Height -= d
+		}
+	}
+	if c&cornerS != 0 {
+		if d := snapOffset([]int{bottom}, ys, snapdist, 0); -d < win.Layout.Height {
+			win.Layout.Height += d
+		}
+	}
+	// Snapping mustn't take the size off the window's size
+	// increments, keeping the edges that aren't dragged in place.
+	w, h := win.constrainSize(win.Layout.Width, win.Layout.Height)
+	if c&cornerW != 0 {
+		win.Layout.X += win.Layout.Width - w
+	}
+	if c&cornerN != 0 {
+		win.Layout.Y += win.Layout.Height - h
+	}
+	win.Layout.Width, win.Layout.Height = w, h
+}
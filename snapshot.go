@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/BurntSushi/xgb/xproto"
+
+	"honnef.co/go/gwm/menu"
+)
+
+// windowSnapshot is the saved arrangement of a single window.
+type windowSnapshot struct {
+	ID             xproto.Window
+	Class          string
+	Instance       string
+	Name           string
+	Geometry       Geometry
+	UnmaximizeGeom Geometry
+	State          MaximizedState
+	Layer          Layer
+	Frozen         bool
+}
+
+// snapshotPath returns the path of the file that snapshots are
+// persisted in.
+func snapshotPath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".local", "state")
+	}
+	return filepath.Join(dir, "gwm", "snapshots.json")
+}
+
+func loadSnapshots() (map[string][]windowSnapshot, error) {
+	snaps := make(map[string][]windowSnapshot)
+	b, err := ioutil.ReadFile(snapshotPath())
+	if os.IsNotExist(err) {
+		return snaps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &snaps); err != nil {
+		return nil, err
+	}
+	return snaps, nil
+}
+
+func saveSnapshots(snaps map[string][]windowSnapshot) error {
+	p := snapshotPath()
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(snaps, "", "\t")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so that a failed write doesn't
+	// destroy existing snapshots.
+	if err := ioutil.WriteFile(p+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+// snapshot returns the current arrangement of the window.
+func (win *Window) snapshot() windowSnapshot {
+	instance, class := win.Class()
+	s := windowSnapshot{
+		ID:             win.Id,
+		Class:          class,
+		Instance:       instance,
+		Name:           win.Name(),
+		Geometry:       win.Layout.Geometry,
+		UnmaximizeGeom: win.unmaximizeGeom,
+		State:          win.Layout.State,
+		Layer:          win.Layer,
+		Frozen:         win.frozen,
+	}
+	if (win.Layout.State & Fullscreen) > 0 {
+		// Fullscreen windows are frozen and in the above layer; save
+		// what they will return to instead.
+		s.Geometry = win.unfullscreenGeom
+		s.Layer = win.unfullscreenLayer
+		s.Frozen = false
+	}
+	return s
+}
+
+// restoreSnapshot applies a saved arrangement to the window.
+func (win *Window) restoreSnapshot(s windowSnapshot) {
+	win.Unfullscreen()
+	win.PushLayout()
+	win.unmaximizeGeom = s.UnmaximizeGeom
+	win.ApplyLayout(Layout{Geometry: s.Geometry, State: s.State &^ Fullscreen})
+	win.SetLayer(s.Layer)
+	if s.Frozen {
+		win.Freeze()
+	} else {
+		win.Unfreeze()
+	}
+	if (s.State & Fullscreen) > 0 {
+		win.Fullscreen()
+	}
+}
+
+// SaveSnapshot saves the arrangement of all managed windows under
+// name, replacing an existing snapshot of the same name.
+func (wm *WM) SaveSnapshot(name string) error {
+	snaps, err := loadSnapshots()
+	if err != nil {
+		return err
+	}
+	var snap []windowSnapshot
+	for _, win := range wm.clientWindows() {
+		snap = append(snap, win.snapshot())
+	}
+	snaps[name] = snap
+	return saveSnapshots(snaps)
+}
+
+// RestoreSnapshot restores the arrangement saved under name. Windows
+// are matched by their ID first. Windows that don't match by ID, for
+// example because gwm or the applications were restarted, are
+// matched by their class, instance and name instead. The restoration
+// can be undone with undoall.
+func (wm *WM) RestoreSnapshot(name string) error {
+	snaps, err := loadSnapshots()
+	if err != nil {
+		return err
+	}
+	snap, ok := snaps[name]
+	if !ok {
+		return fmt.Errorf("no snapshot named %q", name)
+	}
+
+	wins := wm.clientWindows()
+	used := make([]bool, len(snap))
+	matches := make(map[*Window]int)
+	for _, win := range wins {
+		_, class := win.Class()
+		for i, s := range snap {
+			if !used[i] && s.ID == win.Id && s.Class == class {
+				used[i] = true
+				matches[win] = i
+				break
+			}
+		}
+	}
+	for _, win := range wins {
+		if _, ok := matches[win]; ok {
+			continue
+		}
+		instance, class := win.Class()
+		name := win.Name()
+		for i, s := range snap {
+			if !used[i] && s.Class == class && s.Instance == instance && s.Name == name {
+				used[i] = true
+				matches[win] = i
+				break
+			}
+		}
+	}
+
+	wm.beginTransaction()
+	for _, win := range wins {
+		if i, ok := matches[win]; ok {
+			win.restoreSnapshot(snap[i])
+		}
+	}
+	wm.endTransaction(nil)
+	return nil
+}
+
+// snapshotMenu shows a menu of the saved snapshots and calls fn with
+// the selected name. If allowNew is true, a name that isn't in the
+// menu can be entered.
+func (wm *WM) snapshotMenu(title string, allowNew bool, fn func(name string) error) {
+	snaps, err := loadSnapshots()
+	if err != nil {
+		log.Println("Could not load snapshots:", err)
+		return
+	}
+	var entries []menu.Entry
+	for name := range snaps {
+		entries = append(entries, menu.Entry{Display: name, Payload: name})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Display < entries[j].Display })
+	m, err := wm.newMenu(title, entries, menu.FilterPrefix)
+	if err != nil {
+		log.Println("Could not display menu:", err)
+		return
+	}
+	m.Show()
+	go func() {
+		ret, ok := m.Wait()
+		if !ok || (ret.Synthetic() && (!allowNew || ret.Payload.(string) == "")) {
+			return
+		}
+		wm.chFn <- func() {
+			if err := fn(ret.Payload.(string)); err != nil {
+				log.Printf("%s %q: %s", title, ret.Payload, err)
+			}
+		}
+	}()
+}
+
+func (wm *WM) saveSnapshotMenu() {
+	wm.snapshotMenu("save snapshot", true, wm.SaveSnapshot)
+}
+
+func (wm *WM) restoreSnapshotMenu() {
+	wm.snapshotMenu("restore snapshot", false, wm.RestoreSnapshot)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/xgbutil/ewmh"
+	"github.com/BurntSushi/xgbutil/icccm"
+)
+
+// parentPid returns the PID of the parent of the process pid.
+func parentPid(pid int) (int, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The second field is the command name in parentheses, which may
+	// itself contain spaces and parentheses.
+	stat := string(b)
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, errors.New("malformed /proc/" + strconv.Itoa(pid) + "/stat")
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 2 {
+		return 0, errors.New("malformed /proc/" + strconv.Itoa(pid) + "/stat")
+	}
+	return strconv.Atoi(fields[1])
+}
+
+// isDescendant reports whether the process pid is a descendant of
+// the process ancestor.
+func isDescendant(pid, ancestor int) bool {
+	for pid > 1 {
+		ppid, err := parentPid(pid)
+		if err != nil {
+			return false
+		}
+		if ppid == ancestor {
+			return true
+		}
+		pid = ppid
+	}
+	return false
+}
+
+// isTerminal reports whether win's class is one of the configured
+// swallowing terminals.
+func (win *Window) isTerminal() bool {
+	name, class := win.Class()
+	for _, c := range win.wm.Config.Swallow {
+		if c == class || c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// swallowingTerminal returns the managed terminal that win has been
+// started from and that should be swallowed by it, or nil.
+func (wm *WM) swallowingTerminal(win *Window) *Window {
+	if len(wm.Config.Swallow) == 0 || win.isTerminal() {
+		return nil
+	}
+	if _, err := icccm.WmTransientForGet(wm.X, win.Id); err == nil {
+		// don't let dialogs swallow the terminal
+		return nil
+	}
+	pid, err := ewmh.WmPidGet(wm.X, win.Id)
+	if err != nil {
+		return nil
+	}
+	for _, term := range wm.Windows {
+		if term == win || !term.Mapped || term.swallowedBy != nil || !term.isTerminal() {
+			continue
+		}
+		tpid, err := ewmh.WmPidGet(wm.X, term.Id)
+		if err != nil {
+			continue
+		}
+		if isDescendant(int(pid), int(tpid)) {
+			return term
+		}
+	}
+	return nil
+}
+
+// layoutGeometry returns the geometry the window has outside of
+// fullscreen.
+func (win *Window) layoutGeometry() Geometry {
+	if (win.Layout.State & Fullscreen) > 0 {
+		return win.unfullscreenGeom
+	}
+	return win.Layout.Geometry
+}
+
+// swallow makes win take the place of the terminal term, which gets
+// hidden until win goes away.
+func (win *Window) swallow(term *Window) {
+	LogWindowEvent(win, fmt.Sprintf("Swallowing terminal %d", term.Id))
+	win.Layout.Geometry = term.layoutGeometry()
+	win.Layout.State = term.Layout.State & MaximizedFull
+	win.unmaximizeGeom = term.unmaximizeGeom
+	win.moveAndResizeNoReset()
+	win.updateWmState()
+
+	win.swallowed = term
+	term.swallowedBy = win
+	term.Iconify()
+}
+
+// unswallow restores the terminal swallowed by win in win's place.
+func (win *Window) unswallow() {
+	term := win.swallowed
+	if term == nil {
+		return
+	}
+	win.swallowed = nil
+	term.swallowedBy = nil
+	if win.wm.Windows[term.Id] != term {
+		return
+	}
+
+	LogWindowEvent(term, "Restoring swallowed terminal")
+	term.Layout.Geometry = win.layoutGeometry()
+	term.Layout.State = win.Layout.State & MaximizedFull
+	term.unmaximizeGeom = win.unmaximizeGeom
+	term.moveAndResizeNoReset()
+	term.updateWmState()
+	term.Deiconify()
+	term.Raise()
+	if win.wm.CurWindow == win {
+		term.markActive()
+	}
+}
+
+// escapeSwallow ends the swallowing of the terminal win, leaving it
+// and the window that swallowed it where they are. It is used when
+// the terminal is shown or withdrawn other than by unswallowing it.
+func (win *Window) escapeSwallow() {
+	by := win.swallowedBy
+	if by == nil {
+		return
+	}
+	LogWindowEvent(win, "Swallowed terminal changed state, no longer swallowing it")
+	by.swallowed = nil
+	win.swallowedBy = nil
+}
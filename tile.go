@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil/icccm"
+)
+
+const (
+	// edgeTileZone is the distance from a screen edge within which
+	// the pointer touches the edge.
+	edgeTileZone = 2
+	// edgeTileCorner is the size of the screen corners that tile
+	// windows to a quadrant instead of a half.
+	edgeTileCorner = 64
+)
+
+// innerGeometry returns the geometry that makes the window, including
+// its border, occupy outer.
+func (win *Window) innerGeometry(outer Geometry) Geometry {
+	return Geometry{
+		X:      outer.X,
+		Y:      outer.Y,
+		Width:  outer.Width - 2*win.BorderWidth,
+		Height: outer.Height - 2*win.BorderWidth,
+	}
+}
+
+// edgeTile returns the half or quadrant of the work area that a
+// window dragged to p should be tiled to. It reports false if p
+// doesn't touch a screen edge.
+func (wm *WM) edgeTile(p Point) (Geometry, bool) {
+	screen := screenForPoint(wm.Screens(), p)
+	left := p.X < screen.X+edgeTileZone
+	right := p.X >= screen.X+screen.Width-edgeTileZone
+	top := p.Y < screen.Y+edgeTileZone
+	bottom := p.Y >= screen.Y+screen.Height-edgeTileZone
+	switch {
+	case left || right:
+		top = p.Y < screen.Y+edgeTileCorner
+		bottom = p.Y >= screen.Y+screen.Height-edgeTileCorner
+	case top || bottom:
+		left = p.X < screen.X+edgeTileCorner
+		right = p.X >= screen.X+screen.Width-edgeTileCorner
+	default:
+		return Geometry{}, false
+	}
+
+	g := wm.workarea(screen)
+	if left {
+		g.Width /= 2
+	} else if right {
+		g.X += g.Width / 2
+		g.Width -= g.Width / 2
+	}
+	if top {
+		g.Height /= 2
+	} else if bottom {
+		g.Y += g.Height / 2
+		g.Height -= g.Height / 2
+	}
+	return g, true
+}
+
+// updateEdgeTile shows or hides the preview of the tile that the
+// window will occupy if it is dropped with the pointer at p.
+func (win *Window) updateEdgeTile(p Point) {
+	d := win.curDrag
+	g, ok := win.wm.edgeTile(p)
+	if !ok {
+		if d.tile != nil {
+			d.tile.Destroy()
+			d.tile = nil
+		}
+		return
+	}
+	if d.tile == nil {
+		bw := win.BorderWidth
+		if bw < 1 {
+			bw = 1
+		}
+		r, err := win.wm.NewRectangle(uint16(bw), win.wm.Color(win.wm.Config.Colors["activeborder"]))
+		if err != nil {
+			log.Println("couldn't create rectangle:", err)
+			return
+		}
+		r.Show()
+		d.tile = r
+	}
+	d.tileGeom = g
+	inner := win.innerGeometry(g)
+	d.tile.MoveAndResize(inner.X+win.BorderWidth, inner.Y+win.BorderWidth, inner.Width, inner.Height)
+}
+
+// untile restores the size the window had before it was tiled to a
+// screen edge, keeping the pointer at the same relative position in
+// the window.
+func (win *Window) untile() {
+	d := win.curDrag
+	g := *win.untiledGeom
+	win.untiledGeom = nil
+
+	w, h := win.outerSize()
+	px := d.offset.X - d.start.X
+	py := d.offset.Y - d.start.Y
+	win.Layout.Width = g.Width
+	win.Layout.Height = g.Height
+	nw, nh := win.outerSize()
+	px = px * nw / w
+	if py >= nh {
+		py = py * nh / h
+	}
+	d.start = Point{d.offset.X - px, d.offset.Y - py}
+	win.resize()
+}
+
+// gridCell is a span of w×h cells, starting at column x and row y, of
+// a grid of cols×rows cells.
+type gridCell struct {
+	cols, rows int
+	x, y, w, h int
+}
+
+// parseGridCell parses the arguments of the grid command: the number
+// of columns and rows, and the column, row, width and height of the
+// span, counting cells from 0.
+func parseGridCell(args []string) (gridCell, error) {
+	if len(args) != 6 {
+		return gridCell{}, fmt.Errorf("grid expects 6 arguments, got %d", len(args))
+	}
+	var v [6]int
+	for i, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return gridCell{}, err
+		}
+		v[i] = n
+	}
+	c := gridCell{cols: v[0], rows: v[1], x: v[2], y: v[3], w: v[4], h: v[5]}
+	if c.cols < 1 || c.rows < 1 || c.x < 0 || c.y < 0 || c.w < 1 || c.h < 1 ||
+		c.x+c.w > c.cols || c.y+c.h > c.rows {
+		return gridCell{}, fmt.Errorf("invalid grid span %v", args)
+	}
+	return c, nil
+}
+
+// geometry returns the part of area that the span covers.
+func (c gridCell) geometry(area Geometry) Geometry {
+	x0 := area.X + area.Width*c.x/c.cols
+	x1 := area.X + area.Width*(c.x+c.w)/c.cols
+	y0 := area.Y + area.Height*c.y/c.rows
+	y1 := area.Y + area.Height*(c.y+c.h)/c.rows
+	return Geometry{X: x0, Y: y0, Width: x1 - x0, Height: y1 - y0}
+}
+
+// gridSizes are the fractions of the work area that snapping a window
+// to the same edge repeatedly cycles through.
+var gridSizes = [...]struct{ n, d int }{{1, 2}, {1, 3}, {2, 3}}
+
+// anchorCells returns the spans that snapping to an edge or corner
+// cycles through. xf and yf anchor the window to the left or top (-1)
+// or right or bottom (1) edge, or make it span the whole axis (0).
+// Corners only cycle the width and always take half of the height.
+func anchorCells(xf, yf int) []gridCell {
+	var cells []gridCell
+	for _, size := range gridSizes {
+		c := gridCell{cols: 1, rows: 1, w: 1, h: 1}
+		if xf != 0 {
+			c.cols, c.w = size.d, size.n
+			if xf > 0 {
+				c.x = size.d - size.n
+			}
+		}
+		vn, vd := size.n, size.d
+		if xf != 0 && yf != 0 {
+			vn, vd = 1, 2
+		}
+		if yf != 0 {
+			c.rows, c.h = vd, vn
+			if yf > 0 {
+				c.y = vd - vn
+			}
+		}
+		cells = append(cells, c)
+	}
+	return cells
+}
+
+// gridSnap records the last grid placement of a window, so that
+// repeating it can cycle through spans.
+type gridSnap struct {
+	cell gridCell
+	geom Geometry
+}
+
+// PlaceInGrid places the window in a span of cells of a grid of its
+// screen's work area.
+func (win *Window) PlaceInGrid(c gridCell) {
+	if (win.Layout.State & Fullscreen) > 0 {
+		LogWindowEvent(win, "Not snapping fullscreen window")
+		return
+	}
+	area := win.wm.workarea(win.Screen())
+	win.PushLayout()
+	win.Layout.Geometry = win.innerGeometry(c.geometry(area))
+	win.moveAndResize()
+	win.gridSnap = gridSnap{c, win.Layout.Geometry}
+}
+
+// SnapToGrid places the window in the first of cells, or, if the
+// window is still where it was last placed in one of them, in the one
+// after that.
+func (win *Window) SnapToGrid(cells []gridCell) {
+	next := cells[0]
+	if win.gridSnap.geom == win.Layout.Geometry {
+		for i, c := range cells {
+			if c == win.gridSnap.cell {
+				next = cells[(i+1)%len(cells)]
+				break
+			}
+		}
+	}
+	win.PlaceInGrid(next)
+}
+
+// constrainSize returns the largest size not larger than w×h that
+// honours the window's size increments and minimum and maximum sizes.
+func (win *Window) constrainSize(w, h int) (int, int) {
+	hints, err := icccm.WmNormalHintsGet(win.wm.X, win.Id)
+	if err != nil {
+		return w, h
+	}
+	var wBase, hBase int
+	if (hints.Flags & icccm.SizeHintPBaseSize) > 0 {
+		wBase, hBase = int(hints.BaseWidth), int(hints.BaseHeight)
+	} else if (hints.Flags & icccm.SizeHintPMinSize) > 0 {
+		wBase, hBase = int(hints.MinWidth), int(hints.MinHeight)
+	}
+	if (hints.Flags & icccm.SizeHintPResizeInc) > 0 {
+		if w > wBase {
+			w = wBase + roundDown(w-wBase, int(hints.WidthInc))
+		}
+		if h > hBase {
+			h = hBase + roundDown(h-hBase, int(hints.HeightInc))
+		}
+	}
+	if (hints.Flags & icccm.SizeHintPMaxSize) > 0 {
+		if hints.MaxWidth > 0 && w > int(hints.MaxWidth) {
+			w = int(hints.MaxWidth)
+		}
+		if hints.MaxHeight > 0 && h > int(hints.MaxHeight) {
+			h = int(hints.MaxHeight)
+		}
+	}
+	if (hints.Flags & icccm.SizeHintPMinSize) > 0 {
+		if w < int(hints.MinWidth) {
+			w = int(hints.MinWidth)
+		}
+		if h < int(hints.MinHeight) {
+			h = int(hints.MinHeight)
+		}
+	}
+	return w, h
+}
+
+// tileTo makes the window, including its border, occupy as much of
+// outer as its size hints allow.
+func (win *Window) tileTo(outer Geometry) {
+	win.PushLayout()
+	win.Layout.Geometry = win.innerGeometry(outer)
+	win.Layout.Width, win.Layout.Height = win.constrainSize(win.Layout.Width, win.Layout.Height)
+	win.moveAndResize()
+}
+
+// tileable returns the mapped windows on screen that tiling
+// arranges, except for except.
+func (wm *WM) tileable(screen Geometry, except *Window) []*Window {
+	var out []*Window
+	for _, win := range wm.MappedWindows() {
+		if win == except || win == wm.Root || win.Screen() != screen {
+			continue
+		}
+		if win.frozen || (win.Layout.State&Fullscreen) > 0 || win.TransientFor() != xproto.WindowNone {
+			continue
+		}
+		if win.HasType("_NET_WM_WINDOW_TYPE_DOCK") || win.HasType("_NET_WM_WINDOW_TYPE_DESKTOP") {
+			continue
+		}
+		out = append(out, win)
+	}
+	return out
+}
+
+// split returns the offset and length of the i-th of n equal parts
+// of a line of length l starting at off.
+func split(off, l, i, n int) (int, int) {
+	start := off + l*i/n
+	end := off + l*(i+1)/n
+	return start, end - start
+}
+
+// HTile gives the window the top half of its screen's work area and
+// places the other windows on the screen next to each other in the
+// bottom half.
+func (win *Window) HTile() {
+	win.tileMaster(true)
+}
+
+// VTile gives the window the left half of its screen's work area
+// and stacks the other windows on the screen in the right half.
+func (win *Window) VTile() {
+	win.tileMaster(false)
+}
+
+func (win *Window) tileMaster(horizontal bool) {
+	if win.frozen || (win.Layout.State&Fullscreen) > 0 {
+		LogWindowEvent(win, "Not tiling frozen or fullscreen window")
+		return
+	}
+	screen := win.Screen()
+	others := win.wm.tileable(screen, win)
+	if len(others) == 0 {
+		return
+	}
+	area := win.wm.workarea(screen)
+	master, rest := area, area
+	if horizontal {
+		master.Height /= 2
+		rest.Y += master.Height
+		rest.Height -= master.Height
+	} else {
+		master.Width /= 2
+		rest.X += master.Width
+		rest.Width -= master.Width
+	}
+	win.wm.beginTransaction()
+	defer win.wm.endTransaction(nil)
+	win.tileTo(master)
+	for i, other := range others {
+		g := rest
+		if horizontal {
+			g.X, g.Width = split(rest.X, rest.Width, i, len(others))
+		} else {
+			g.Y, g.Height = split(rest.Y, rest.Height, i, len(others))
+		}
+		other.tileTo(g)
+	}
+}
+
+// gridCells returns the geometries of n cells of a grid that fills
+// area. The grid has as many rows as columns, or one fewer; the
+// cells of an incomplete last row share its width.
+func gridCells(area Geometry, n int) []Geometry {
+	if n == 0 {
+		return nil
+	}
+	cols := 1
+	for cols*cols < n {
+		cols++
+	}
+	rows := (n + cols - 1) / cols
+	cells := make([]Geometry, 0, n)
+	for i := 0; i < n; i++ {
+		row := i / cols
+		inRow := cols
+		if row == rows-1 {
+			inRow = n - row*cols
+		}
+		var g Geometry
+		g.X, g.Width = split(area.X, area.Width, i%cols, inRow)
+		g.Y, g.Height = split(area.Y, area.Height, row, rows)
+		cells = append(cells, g)
+	}
+	return cells
+}
+
+// GridTile arranges all windows on the current screen in a grid,
+// keeping their rough order from top left to bottom right.
+func (wm *WM) GridTile() {
+	screen := wm.CurrentScreen()
+	wins := wm.tileable(screen, nil)
+	sort.SliceStable(wins, func(i, j int) bool {
+		if wins[i].Layout.Y != wins[j].Layout.Y {
+			return wins[i].Layout.Y < wins[j].Layout.Y
+		}
+		return wins[i].Layout.X < wins[j].Layout.X
+	})
+	wm.beginTransaction()
+	for i, g := range gridCells(wm.workarea(screen), len(wins)) {
+		wins[i].tileTo(g)
+	}
+	wm.endTransaction(nil)
+}
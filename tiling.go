@@ -0,0 +1,177 @@
+package main
+
+import (
+	"sort"
+
+	"honnef.co/go/gwm/config"
+)
+
+// tiling is the automatic tiling state of a screen.
+type tiling struct {
+	layout config.TileLayout
+	// ratio is the width of the master area, in percent.
+	ratio   int
+	masters int
+}
+
+// tilingFor returns the tiling state of screen.
+func (wm *WM) tilingFor(screen Geometry) *tiling {
+	if t, ok := wm.tilings[screen]; ok {
+		return t
+	}
+	if wm.tilings == nil {
+		wm.tilings = make(map[Geometry]*tiling)
+	}
+	t := &tiling{
+		layout:  wm.Config.Tiling,
+		ratio:   wm.Config.MasterRatio,
+		masters: wm.Config.MasterCount,
+	}
+	wm.tilings[screen] = t
+	return t
+}
+
+// arranged returns the windows on screen that are arranged
+// automatically, in the order they were managed in.
+func (wm *WM) arranged(screen Geometry) []*Window {
+	var out []*Window
+	for _, win := range wm.tileable(screen, nil) {
+		if !win.floating {
+			out = append(out, win)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].seq < out[j].seq })
+	return out
+}
+
+// masterStackCells returns the geometries of n windows in the
+// master-stack layout.
+func masterStackCells(area Geometry, n, masters, ratio int) []Geometry {
+	if masters > n {
+		masters = n
+	}
+	master, stack := area, area
+	if n > masters {
+		master.Width = area.Width * ratio / 100
+		stack.X += master.Width
+		stack.Width -= master.Width
+	}
+	cells := make([]Geometry, 0, n)
+	for i := 0; i < n; i++ {
+		var g Geometry
+		if i < masters {
+			g = master
+			g.Y, g.Height = split(master.Y, master.Height, i, masters)
+		} else {
+			g = stack
+			g.Y, g.Height = split(stack.Y, stack.Height, i-masters, n-masters)
+		}
+		cells = append(cells, g)
+	}
+	return cells
+}
+
+// arrange arranges the windows on screen according to the screen's
+// tiling layout.
+func (wm *WM) arrange(screen Geometry) {
+	t := wm.tilingFor(screen)
+	if t.layout == config.TileNone {
+		return
+	}
+	wins := wm.arranged(screen)
+	area := wm.workarea(screen)
+	var cells []Geometry
+	switch t.layout {
+	case config.TileMasterStack:
+		cells = masterStackCells(area, len(wins), t.masters, t.ratio)
+	case config.TileMonocle:
+		for range wins {
+			cells = append(cells, area)
+		}
+	case config.TileGrid:
+		cells = gridCells(area, len(wins))
+	}
+	for i, win := range wins {
+		g := win.innerGeometry(cells[i])
+		g.Width, g.Height = win.constrainSize(g.Width, g.Height)
+		if g == win.Layout.Geometry {
+			continue
+		}
+		win.Layout.Geometry = g
+		win.moveAndResizeNoReset()
+	}
+	if t.layout == config.TileMonocle {
+		for _, win := range wins {
+			if win == wm.CurWindow {
+				win.Raise()
+			}
+		}
+	}
+}
+
+// arrangeAll arranges the windows on all screens.
+func (wm *WM) arrangeAll() {
+	for _, screen := range wm.Screens() {
+		wm.arrange(screen)
+	}
+}
+
+// SetTiling sets the tiling layout of the current screen. Switching
+// from no layout to one pushes the layouts of the affected windows,
+// so that they can be restored with poplayout.
+func (wm *WM) SetTiling(layout config.TileLayout) {
+	screen := wm.CurrentScreen()
+	t := wm.tilingFor(screen)
+	if t.layout == config.TileNone {
+		wm.beginTransaction()
+		for _, win := range wm.arranged(screen) {
+			win.PushLayout()
+		}
+		wm.endTransaction(nil)
+	}
+	t.layout = layout
+	wm.arrange(screen)
+}
+
+// NextTiling switches the current screen to the next tiling layout.
+func (wm *WM) NextTiling() {
+	t := wm.tilingFor(wm.CurrentScreen())
+	wm.SetTiling((t.layout + 1) % (config.TileGrid + 1))
+}
+
+// AdjustMaster changes the master ratio of the current screen by
+// dratio percent and its number of master windows by dcount.
+func (wm *WM) AdjustMaster(dratio, dcount int) {
+	screen := wm.CurrentScreen()
+	t := wm.tilingFor(screen)
+	t.ratio += dratio
+	if t.ratio < 5 {
+		t.ratio = 5
+	} else if t.ratio > 95 {
+		t.ratio = 95
+	}
+	t.masters += dcount
+	if t.masters < 1 {
+		t.masters = 1
+	}
+	wm.arrange(screen)
+}
+
+// ToggleFloating exempts the window from automatic tiling, or
+// subjects it to it again.
+func (win *Window) ToggleFloating() {
+	win.floating = !win.floating
+	win.wm.arrange(win.Screen())
+}
+
+func wmtilingfunc(layout config.TileLayout) func(*WM) {
+	return func(wm *WM) {
+		wm.SetTiling(layout)
+	}
+}
+
+func wmmasterfunc(dratio, dcount int) func(*WM) {
+	return func(wm *WM) {
+		wm.AdjustMaster(dratio, dcount)
+	}
+}